@@ -0,0 +1,959 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode"
+
+	"github.com/ekino/godim/cmd/godim/internal/tag"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+//go:generate cp ../../internal/tag/tag.go internal/tag/tag.go
+
+const (
+	godimPath  = "github.com/ekino/godim"
+	defaultStr = "default"
+)
+
+// options of the gen command
+type options struct {
+	dir      string
+	funcName string
+	output   string
+	inject   string
+	config   string
+	profile  appProfile
+}
+
+// appProfile maps a layer to the layers it can be injected in, as godim.AppProfile
+type appProfile map[string]map[string]bool
+
+func (ap appProfile) validate(label string) bool {
+	return ap[label] != nil
+}
+
+func (ap appProfile) isDefault() bool {
+	return ap[defaultStr] != nil
+}
+
+func (ap appProfile) validateInjection(from, into string) error {
+	if ap.isDefault() {
+		return nil
+	}
+	if ap[from] == nil {
+		return fmt.Errorf("profile %s does not exist", from)
+	}
+	if !ap[from][into] {
+		return fmt.Errorf("%s can't be injected in %s", from, into)
+	}
+	return nil
+}
+
+func (ap appProfile) add(name string, injectIn ...string) {
+	in := make(map[string]bool)
+	for _, i := range injectIn {
+		in[i] = true
+	}
+	ap[name] = in
+}
+
+func newProfile(name string) (appProfile, error) {
+	ap := make(appProfile)
+	switch name {
+	case defaultStr:
+		ap.add(defaultStr, defaultStr)
+	case "strict":
+		ap.add("handler")
+		ap.add("service", "handler")
+		ap.add("repository", "service")
+		ap.add("driver", "repository")
+	case "http":
+		ap.add("handler")
+		ap.add("service", "handler", "service")
+		ap.add("repository", "service")
+	case "custom":
+	default:
+		return nil, fmt.Errorf("unknown profile %s, use default, http, strict or custom", name)
+	}
+	return ap, nil
+}
+
+// layers is the -layer flag, defining a custom profile
+type layers []string
+
+func (l *layers) String() string {
+	return strings.Join(*l, " ")
+}
+
+func (l *layers) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func runGen(args []string) error {
+	fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "usage: godim gen [flags] [dir]\n\n")
+		fmt.Fprintf(fs.Output(), "Gen writes the wiring of the services declared in a function of the package in dir.\n\n")
+		fs.PrintDefaults()
+	}
+	opts := options{dir: "."}
+	fs.StringVar(&opts.funcName, "func", "declare", "name of the function declaring the services")
+	fs.StringVar(&opts.output, "o", "godim_gen.go", "name of the generated file, in dir")
+	fs.StringVar(&opts.inject, "inject", "inject", "name of the inject tag")
+	fs.StringVar(&opts.config, "config", "config", "name of the config tag")
+	profile := fs.String("profile", defaultStr, "app profile: default, http, strict or custom")
+	var custom layers
+	fs.Var(&custom, "layer", "layer of a custom profile, as name=injectIn,injectIn (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("too many arguments")
+	}
+	if fs.NArg() == 1 {
+		opts.dir = fs.Arg(0)
+	}
+	if len(custom) > 0 {
+		*profile = "custom"
+	}
+	var err error
+	opts.profile, err = newProfile(*profile)
+	if err != nil {
+		return err
+	}
+	for _, l := range custom {
+		name, in, _ := strings.Cut(l, "=")
+		var injectIn []string
+		if in != "" {
+			injectIn = strings.Split(in, ",")
+		}
+		opts.profile.add(strings.TrimSpace(name), injectIn...)
+	}
+	if len(opts.profile) == 0 {
+		return fmt.Errorf("custom profile needs at least one -layer")
+	}
+
+	src, err := generate(opts)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(opts.dir, opts.output), src, 0644)
+}
+
+// holder is a declared service, as godim holder
+type holder struct {
+	label string
+	key   string
+	// typ is the type of the value
+	typ types.Type
+	// elem is the struct pointed by typ, nil when typ is not a pointer to a struct
+	elem *types.Struct
+	// value is the expression building the service, for values and structs
+	value    string
+	provider *types.Func
+	params   []*holder
+	prio     int
+	// name of the field holding the service in the generated app
+	name    string
+	configs []configField
+	injects []injection
+	deps    []*holder
+	onInit  bool
+	onClose bool
+}
+
+func (h *holder) String() string {
+	return h.label + ":" + h.key
+}
+
+type configField struct {
+	// field is the path of the field, sections included, e.g. DB.Pool.Size
+	field string
+	// typ is the type of the field
+	typ types.Type
+	// alloc is set for a pointer to a section, created when nil
+	alloc bool
+	configTag
+}
+
+type injection struct {
+	field string
+	dep   *holder
+	// lazy is the func type of a lazy field
+	lazy *types.Signature
+}
+
+// generator holds the state of a generation
+type generator struct {
+	options
+	pkg      *packages.Package
+	decls    map[*types.Func]*ast.FuncDecl
+	ifaces   map[string]*types.Interface
+	holders  []*holder
+	byLabel  map[string]map[string]*holder
+	names    map[string]bool
+	imports  map[string]string
+	importOf map[string]string
+}
+
+// generate returns the source of the wiring of the services declared in options.funcName
+func generate(opts options) ([]byte, error) {
+	g := &generator{
+		options:  opts,
+		decls:    make(map[*types.Func]*ast.FuncDecl),
+		byLabel:  make(map[string]map[string]*holder),
+		names:    make(map[string]bool),
+		imports:  make(map[string]string),
+		importOf: make(map[string]string),
+	}
+	if err := g.load(); err != nil {
+		return nil, err
+	}
+	if err := g.environments(); err != nil {
+		return nil, err
+	}
+	fd, err := g.declarationFunc()
+	if err != nil {
+		return nil, err
+	}
+	if err := g.declarations(fd); err != nil {
+		return nil, err
+	}
+	if err := g.injection(); err != nil {
+		return nil, err
+	}
+	if err := g.checkLazyOnly(); err != nil {
+		return nil, err
+	}
+	order, err := g.initOrder()
+	if err != nil {
+		return nil, err
+	}
+	return g.write(order)
+}
+
+// load type checks the package, ignoring a previously generated file
+func (g *generator) load() error {
+	out, err := filepath.Abs(filepath.Join(g.dir, g.output))
+	if err != nil {
+		return err
+	}
+	cfg := &packages.Config{
+		Mode:    packages.NeedName | packages.NeedFiles | packages.NeedImports | packages.NeedDeps | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+		Dir:     g.dir,
+		Overlay: make(map[string][]byte),
+	}
+	if f, err := parser.ParseFile(token.NewFileSet(), out, nil, parser.PackageClauseOnly); err == nil {
+		cfg.Overlay[out] = []byte("package " + f.Name.Name + "\n")
+	}
+	pkgs, err := packages.Load(cfg, ".")
+	if err != nil {
+		return err
+	}
+	if len(pkgs) != 1 {
+		return fmt.Errorf("expected one package in %s, found %d", g.dir, len(pkgs))
+	}
+	g.pkg = pkgs[0]
+	for _, e := range g.pkg.Errors {
+		// the generated names are missing until the first generation
+		if e.Kind != packages.TypeError || !g.generatedName(strings.TrimPrefix(e.Msg, "undefined: ")) {
+			return e
+		}
+	}
+	gp := g.pkg.Imports[godimPath]
+	if gp == nil {
+		return fmt.Errorf("package %s does not import %s", g.pkg.Name, godimPath)
+	}
+	g.ifaces = make(map[string]*types.Interface)
+	for _, name := range []string{"Initializer", "Closer"} {
+		g.ifaces[name] = gp.Types.Scope().Lookup(name).Type().Underlying().(*types.Interface)
+	}
+	for _, f := range g.pkg.Syntax {
+		for _, d := range f.Decls {
+			if fd, ok := d.(*ast.FuncDecl); ok {
+				if fn, ok := g.pkg.TypesInfo.Defs[fd.Name].(*types.Func); ok {
+					g.decls[fn] = fd
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (g *generator) generatedName(name string) bool {
+	switch name {
+	case g.appName(), g.newAppName(), g.configureName():
+		return true
+	}
+	return false
+}
+
+func (g *generator) appName() string {
+	return g.funcName + "App"
+}
+
+func (g *generator) newAppName() string {
+	return "new" + identifier(g.funcName, true) + "App"
+}
+
+func (g *generator) configureName() string {
+	return g.funcName + "Configure"
+}
+
+func (g *generator) declarationFunc() (*ast.FuncDecl, error) {
+	fn, ok := g.pkg.Types.Scope().Lookup(g.funcName).(*types.Func)
+	if !ok || g.decls[fn] == nil || g.decls[fn].Body == nil {
+		return nil, fmt.Errorf("function %s not found in package %s", g.funcName, g.pkg.Name)
+	}
+	return g.decls[fn], nil
+}
+
+// errorf returns an error located at pos
+func (g *generator) errorf(pos token.Pos, format string, args ...interface{}) error {
+	return fmt.Errorf("%s: %s", g.pkg.Fset.Position(pos), fmt.Sprintf(format, args...))
+}
+
+// godimMethod returns the name of the Godim method called, if any
+func (g *generator) godimMethod(call *ast.CallExpr) string {
+	return g.method(call, "Godim")
+}
+
+// method returns the name of the method of the godim type recv called, if any
+func (g *generator) method(call *ast.CallExpr, recv string) string {
+	fn, ok := typeutil.Callee(g.pkg.TypesInfo, call).(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != godimPath {
+		return ""
+	}
+	r := fn.Type().(*types.Signature).Recv()
+	if r == nil {
+		return ""
+	}
+	if p, ok := r.Type().(*types.Pointer); ok {
+		if n, ok := p.Elem().(*types.Named); ok && n.Obj().Name() == recv {
+			return fn.Name()
+		}
+	}
+	return ""
+}
+
+// environments rejects the packages setting active environments,
+// the generated configuration looks up the keys without their environment prefixes
+func (g *generator) environments() error {
+	var err error
+	for _, f := range g.pkg.Syntax {
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if ok && err == nil && g.method(call, "Config") == "WithActiveEnvironments" {
+				err = g.errorf(call.Pos(), "WithActiveEnvironments is not supported by godim gen")
+			}
+			return err == nil
+		})
+	}
+	return err
+}
+
+// declarations reads the Declare calls of fd, in source order
+func (g *generator) declarations(fd *ast.FuncDecl) error {
+	var err error
+	ast.Inspect(fd.Body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok || err != nil {
+			return err == nil
+		}
+		switch m := g.godimMethod(call); m {
+		case "Declare", "DeclareDefault":
+			args := call.Args
+			label := defaultStr
+			if m == "Declare" {
+				l, ok := g.constString(args[0])
+				if !ok {
+					err = g.errorf(args[0].Pos(), "label must be a constant")
+					return false
+				}
+				label, args = l, args[1:]
+			}
+			if call.Ellipsis.IsValid() {
+				err = g.errorf(call.Pos(), "services must be listed, not given as a slice")
+				return false
+			}
+			for _, arg := range args {
+				if err = g.declare(label, arg); err != nil {
+					return false
+				}
+			}
+		case "DeclareValue":
+			err = g.declareValue(call)
+		case "DeclareScoped", "DeclareIf", "Install", "Override":
+			err = g.errorf(call.Pos(), "%s is not supported by godim gen", m)
+		}
+		return err == nil
+	})
+	if err == nil && len(g.holders) == 0 {
+		err = g.errorf(fd.Pos(), "no service declared in %s", g.funcName)
+	}
+	return err
+}
+
+func (g *generator) constString(e ast.Expr) (string, bool) {
+	tv, ok := g.pkg.TypesInfo.Types[e]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
+
+// declare reads a &T{} struct or a provider function
+func (g *generator) declare(label string, arg ast.Expr) error {
+	if !g.profile.validate(label) {
+		return g.errorf(arg.Pos(), "%s is not a declared profile", label)
+	}
+	if fn := g.funcOf(arg); fn != nil {
+		return g.declareProvider(label, arg, fn)
+	}
+	u, ok := arg.(*ast.UnaryExpr)
+	if !ok || u.Op != token.AND {
+		return g.errorf(arg.Pos(), "a service must be declared as &T{} or with a provider function")
+	}
+	lit, ok := u.X.(*ast.CompositeLit)
+	if !ok {
+		return g.errorf(arg.Pos(), "a service must be declared as &T{} or with a provider function")
+	}
+	typ := g.pkg.TypesInfo.TypeOf(arg)
+	named, elem := structOf(typ)
+	if elem == nil || named == nil {
+		return g.errorf(arg.Pos(), "%s is not a named struct, use DeclareValue", typ)
+	}
+	value, err := g.literal(lit)
+	if err != nil {
+		return err
+	}
+	key, err := g.key(named)
+	if err != nil {
+		return g.errorf(arg.Pos(), "%s", err)
+	}
+	prio, err := g.priority(named)
+	if err != nil {
+		return g.errorf(arg.Pos(), "%s", err)
+	}
+	return g.addHolder(arg.Pos(), &holder{label: label, key: key, typ: typ, elem: elem, value: "&" + value, prio: prio})
+}
+
+// literal returns the source of a struct literal whose elements are constants
+func (g *generator) literal(lit *ast.CompositeLit) (string, error) {
+	var elts []string
+	for _, e := range lit.Elts {
+		kv, ok := e.(*ast.KeyValueExpr)
+		if !ok {
+			return "", g.errorf(e.Pos(), "struct literal elements must be keyed")
+		}
+		tv := g.pkg.TypesInfo.Types[kv.Value]
+		if tv.Value == nil {
+			return "", g.errorf(kv.Value.Pos(), "struct literal elements must be constants, use a provider function")
+		}
+		elts = append(elts, fmt.Sprintf("%s: %s", kv.Key.(*ast.Ident).Name, tv.Value.ExactString()))
+	}
+	return g.typeString(g.pkg.TypesInfo.TypeOf(lit)) + "{" + strings.Join(elts, ", ") + "}", nil
+}
+
+// funcOf returns the function named by e, if any
+func (g *generator) funcOf(e ast.Expr) *types.Func {
+	var id *ast.Ident
+	switch e := e.(type) {
+	case *ast.Ident:
+		id = e
+	case *ast.SelectorExpr:
+		id = e.Sel
+	default:
+		return nil
+	}
+	fn, _ := g.pkg.TypesInfo.Uses[id].(*types.Func)
+	if fn == nil || fn.Type().(*types.Signature).Recv() != nil {
+		return nil
+	}
+	return fn
+}
+
+func (g *generator) declareProvider(label string, arg ast.Expr, fn *types.Func) error {
+	sig := fn.Type().(*types.Signature)
+	if sig.Variadic() {
+		return g.errorf(arg.Pos(), "provider %s can't be variadic", fn.Name())
+	}
+	res := sig.Results()
+	if res.Len() < 1 || res.Len() > 2 {
+		return g.errorf(arg.Pos(), "provider %s must return a value and optionally an error", fn.Name())
+	}
+	if res.Len() == 2 && !types.Identical(res.At(1).Type(), types.Universe.Lookup("error").Type()) {
+		return g.errorf(arg.Pos(), "provider %s second return value must be an error", fn.Name())
+	}
+	typ := res.At(0).Type()
+	named, elem := structOf(typ)
+	if elem == nil || named == nil {
+		return g.errorf(arg.Pos(), "provider %s must return a pointer to a struct", fn.Name())
+	}
+	key, err := g.key(named)
+	if err != nil {
+		return g.errorf(arg.Pos(), "%s", err)
+	}
+	prio, err := g.priority(named)
+	if err != nil {
+		return g.errorf(arg.Pos(), "%s", err)
+	}
+	return g.addHolder(arg.Pos(), &holder{label: label, key: key, typ: typ, elem: elem, provider: fn, prio: prio})
+}
+
+// declareValue reads a DeclareValue call whose value is a package level variable
+func (g *generator) declareValue(call *ast.CallExpr) error {
+	label, ok := g.constString(call.Args[0])
+	if !ok {
+		return g.errorf(call.Args[0].Pos(), "label must be a constant")
+	}
+	key, ok := g.constString(call.Args[1])
+	if !ok {
+		return g.errorf(call.Args[1].Pos(), "key must be a constant")
+	}
+	if !g.profile.validate(label) {
+		return g.errorf(call.Pos(), "%s is not a declared profile", label)
+	}
+	arg := call.Args[2]
+	expr := arg
+	prefix := ""
+	if u, ok := arg.(*ast.UnaryExpr); ok && u.Op == token.AND {
+		expr, prefix = u.X, "&"
+	}
+	var id *ast.Ident
+	switch e := expr.(type) {
+	case *ast.Ident:
+		id = e
+	case *ast.SelectorExpr:
+		id = e.Sel
+	}
+	var obj types.Object
+	if id != nil {
+		obj = g.pkg.TypesInfo.Uses[id]
+	}
+	v, ok := obj.(*types.Var)
+	if !ok || v.Pkg() == nil || v.Parent() != v.Pkg().Scope() {
+		return g.errorf(arg.Pos(), "value of %s must be a package level variable", key)
+	}
+	value := prefix + v.Name()
+	if q := g.qualifier(v.Pkg()); q != "" {
+		value = prefix + q + "." + v.Name()
+	}
+	typ := g.pkg.TypesInfo.TypeOf(arg)
+	_, elem := structOf(typ)
+	return g.addHolder(call.Pos(), &holder{label: label, key: key, typ: typ, elem: elem, value: value})
+}
+
+func (g *generator) addHolder(pos token.Pos, h *holder) error {
+	v := g.byLabel[h.label]
+	if v == nil {
+		v = make(map[string]*holder)
+		g.byLabel[h.label] = v
+	}
+	if v[h.key] != nil {
+		return g.errorf(pos, "%s already defined in registry", h.key)
+	}
+	v[h.key] = h
+	g.holders = append(g.holders, h)
+	h.name = g.fieldName(h)
+	h.onInit = types.Implements(h.typ, g.ifaces["Initializer"])
+	h.onClose = types.Implements(h.typ, g.ifaces["Closer"])
+	if h.elem == nil {
+		return nil
+	}
+	configs, err := g.configFields(h.elem, "", "", []*types.Struct{h.elem})
+	if err != nil {
+		return g.errorf(pos, "%s", err)
+	}
+	h.configs = configs
+	return g.checkTags(pos, h)
+}
+
+// checkTags validates the inject tags of h against the profile, as declareTags
+func (g *generator) checkTags(pos token.Pos, h *holder) error {
+	for i := 0; i < h.elem.NumFields(); i++ {
+		itag, ok := reflect.StructTag(h.elem.Tag(i)).Lookup(g.inject)
+		if !ok {
+			continue
+		}
+		it, err := parseInjectTag(itag)
+		if err != nil {
+			return g.errorf(pos, "%s", err)
+		}
+		if it.auto || g.profile.isDefault() {
+			continue
+		}
+		if strings.Count(it.target, ":") != 1 {
+			return g.errorf(pos, "wrong number of argument when declaring tag %s", it.target)
+		}
+		if !g.profile.validate(it.label) {
+			return g.errorf(pos, "profile %s does not exist", it.label)
+		}
+		if err := g.profile.validateInjection(it.label, h.label); err != nil {
+			return g.errorf(pos, "%s", err)
+		}
+	}
+	return nil
+}
+
+// fieldName returns a unique identifier for h, made of its label and key
+func (g *generator) fieldName(h *holder) string {
+	name := identifier(h.label, false) + identifier(h.key, true)
+	base := name
+	for i := 2; g.names[name]; i++ {
+		name = fmt.Sprintf("%s%d", base, i)
+	}
+	g.names[name] = true
+	return name
+}
+
+func identifier(s string, exported bool) string {
+	var b strings.Builder
+	upper := exported
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = b.Len() > 0 || exported
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+		} else if b.Len() == 0 {
+			r = unicode.ToLower(r)
+		}
+		upper = false
+		b.WriteRune(r)
+	}
+	if b.Len() == 0 {
+		return "s"
+	}
+	return b.String()
+}
+
+// structOf returns the named struct pointed by typ
+func structOf(typ types.Type) (*types.Named, *types.Struct) {
+	p, ok := typ.(*types.Pointer)
+	if !ok {
+		return nil, nil
+	}
+	named, _ := p.Elem().(*types.Named)
+	st, _ := p.Elem().Underlying().(*types.Struct)
+	return named, st
+}
+
+// key returns the key of a declared struct: the constant returned by its Key method or its type name
+func (g *generator) key(named *types.Named) (string, error) {
+	tv, ok, err := g.constMethod(named, "Key")
+	if err != nil || !ok {
+		return named.Obj().Name(), err
+	}
+	if tv.Kind() != constant.String {
+		return "", fmt.Errorf("%s.Key must return a constant string", named.Obj().Name())
+	}
+	return constant.StringVal(tv), nil
+}
+
+func (g *generator) priority(named *types.Named) (int, error) {
+	tv, ok, err := g.constMethod(named, "Priority")
+	if err != nil || !ok {
+		return 0, err
+	}
+	prio, exact := constant.Int64Val(tv)
+	if tv.Kind() != constant.Int || !exact {
+		return 0, fmt.Errorf("%s.Priority must return a constant int", named.Obj().Name())
+	}
+	return int(prio), nil
+}
+
+// constMethod returns the constant returned by the method name of named, if it has one
+func (g *generator) constMethod(named *types.Named, name string) (constant.Value, bool, error) {
+	obj, _, _ := types.LookupFieldOrMethod(types.NewPointer(named), true, named.Obj().Pkg(), name)
+	fn, ok := obj.(*types.Func)
+	if !ok {
+		return nil, false, nil
+	}
+	fd := g.decls[fn]
+	if fd == nil || fd.Body == nil || len(fd.Body.List) != 1 {
+		return nil, false, fmt.Errorf("%s.%s must return a constant declared in package %s", named.Obj().Name(), name, g.pkg.Name)
+	}
+	ret, ok := fd.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 || g.pkg.TypesInfo.Types[ret.Results[0]].Value == nil {
+		return nil, false, fmt.Errorf("%s.%s must return a constant", named.Obj().Name(), name)
+	}
+	return g.pkg.TypesInfo.Types[ret.Results[0]].Value, true, nil
+}
+
+// injectTag is the parsed form of an inject tag, see tag.Inject
+type injectTag struct {
+	target   string
+	label    string
+	key      string
+	auto     bool
+	optional bool
+}
+
+func parseInjectTag(itag string) (injectTag, error) {
+	t, err := tag.ParseInject(itag)
+	return injectTag{target: t.Target, label: t.Label, key: t.Key, auto: t.Auto, optional: t.Optional}, err
+}
+
+// configTag is the parsed form of a config tag, see tag.Config
+type configTag struct {
+	key        string
+	def        string
+	hasDefault bool
+	required   bool
+}
+
+func parseConfigTag(ctag string) (configTag, error) {
+	t, err := tag.ParseConfig(ctag)
+	return configTag{key: t.Key, def: t.Def, hasDefault: t.HasDefault, required: t.Required}, err
+}
+
+// injection resolves the provider parameters and the inject tags of every holder
+func (g *generator) injection() error {
+	for _, h := range g.holders {
+		if h.provider != nil {
+			params := h.provider.Type().(*types.Signature).Params()
+			for i := 0; i < params.Len(); i++ {
+				dep, err := g.resolveParam(h, params.At(i).Type())
+				if err != nil {
+					return err
+				}
+				h.params = append(h.params, dep)
+				h.deps = append(h.deps, dep)
+			}
+		}
+		if h.elem == nil {
+			continue
+		}
+		for i := 0; i < h.elem.NumFields(); i++ {
+			field := h.elem.Field(i)
+			itag, ok := reflect.StructTag(h.elem.Tag(i)).Lookup(g.inject)
+			if !ok {
+				continue
+			}
+			if err := g.injectField(h, field, itag); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (g *generator) injectField(h *holder, field *types.Var, itag string) error {
+	it, _ := parseInjectTag(itag)
+	typ := field.Type()
+	lazy := lazySignature(typ)
+	if lazy != nil {
+		typ = lazy.Results().At(0).Type()
+	} else if isGodimLazy(typ) {
+		return fmt.Errorf("field %s of %s: Lazy fields are not supported by godim gen, use a func() T field", field.Name(), h)
+	}
+	var dep *holder
+	if it.auto {
+		candidates := g.findByType(h, typ)
+		switch len(candidates) {
+		case 0:
+			if !it.optional {
+				return fmt.Errorf("no declared service assignable to %s for field %s of %s", g.typeString(typ), field.Name(), h)
+			}
+		case 1:
+			dep = candidates[0]
+			if err := g.profile.validateInjection(dep.label, h.label); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("ambiguous injection of %s in field %s of %s, candidates are: %s", g.typeString(typ), field.Name(), h, holderNames(candidates))
+		}
+	} else {
+		dep = g.byLabel[it.label][it.key]
+		if dep == nil && !it.optional {
+			return fmt.Errorf("unresolved injection of %s in field %s of %s (%s)", it.target, field.Name(), h, g.typeString(typ))
+		}
+	}
+	if dep == nil {
+		return nil
+	}
+	h.injects = append(h.injects, injection{field: field.Name(), dep: dep, lazy: lazy})
+	if lazy == nil {
+		h.deps = append(h.deps, dep)
+	}
+	return nil
+}
+
+// checkLazyOnly rejects the services only injected in lazy fields, godim builds them on first use
+func (g *generator) checkLazyOnly() error {
+	lazy := make(map[*holder]bool)
+	eager := make(map[*holder]bool)
+	for _, h := range g.holders {
+		for _, in := range h.injects {
+			if in.lazy != nil {
+				lazy[in.dep] = true
+			}
+		}
+		for _, d := range h.deps {
+			eager[d] = true
+		}
+	}
+	for _, h := range g.holders {
+		if lazy[h] && !eager[h] {
+			return fmt.Errorf("%s is only injected in lazy fields, its build on first use is not supported by godim gen", h)
+		}
+	}
+	return nil
+}
+
+// lazySignature returns the signature of a func() T field
+func lazySignature(typ types.Type) *types.Signature {
+	sig, ok := typ.Underlying().(*types.Signature)
+	if !ok || sig.Params().Len() != 0 || sig.Results().Len() != 1 {
+		return nil
+	}
+	return sig
+}
+
+func isGodimLazy(typ types.Type) bool {
+	if p, ok := typ.(*types.Pointer); ok {
+		typ = p.Elem()
+	}
+	named, ok := typ.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == godimPath && named.Obj().Name() == "Lazy"
+}
+
+func (g *generator) resolveParam(h *holder, typ types.Type) (*holder, error) {
+	candidates := g.findByType(h, typ)
+	switch len(candidates) {
+	case 0:
+		return nil, fmt.Errorf("no declared service of type %s for provider of %s", g.typeString(typ), h)
+	case 1:
+	default:
+		return nil, fmt.Errorf("several services of type %s for provider of %s: %s", g.typeString(typ), h, holderNames(candidates))
+	}
+	return candidates[0], g.profile.validateInjection(candidates[0].label, h.label)
+}
+
+func (g *generator) findByType(h *holder, typ types.Type) []*holder {
+	var candidates []*holder
+	for _, c := range g.holders {
+		if c != h && types.AssignableTo(c.typ, typ) {
+			candidates = append(candidates, c)
+		}
+	}
+	return candidates
+}
+
+func holderNames(hs []*holder) string {
+	return joinHolders(hs, ", ")
+}
+
+func holderPath(hs []*holder) string {
+	return joinHolders(hs, " -> ")
+}
+
+func joinHolders(hs []*holder, sep string) string {
+	names := make([]string, len(hs))
+	for i, h := range hs {
+		names[i] = h.String()
+	}
+	return strings.Join(names, sep)
+}
+
+// initOrder returns the holders in topological order of their dependencies,
+// ties are broken by priority then declaration order, as godim initOrder
+func (g *generator) initOrder() ([]*holder, error) {
+	if err := g.checkCycles(); err != nil {
+		return nil, err
+	}
+	// providers get their lifecycle when built, after the declared services
+	var declared []*holder
+	for _, h := range g.holders {
+		if h.provider == nil {
+			declared = append(declared, h)
+		}
+	}
+	for _, h := range g.holders {
+		if h.provider != nil {
+			declared = append(declared, h)
+		}
+	}
+	done := make(map[*holder]bool)
+	var order []*holder
+	for len(order) < len(declared) {
+		var next *holder
+		for _, h := range declared {
+			if done[h] || !allDone(h.deps, done) {
+				continue
+			}
+			if next == nil || h.prio < next.prio {
+				next = h
+			}
+		}
+		done[next] = true
+		order = append(order, next)
+	}
+	return order, nil
+}
+
+func allDone(deps []*holder, done map[*holder]bool) bool {
+	for _, d := range deps {
+		if !done[d] {
+			return false
+		}
+	}
+	return true
+}
+
+func (g *generator) checkCycles() error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[*holder]int)
+	var path []*holder
+	var visit func(h *holder) error
+	visit = func(h *holder) error {
+		switch state[h] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != h {
+				start++
+			}
+			return fmt.Errorf("dependency cycle detected: %s -> %s", holderPath(path[start:]), h)
+		}
+		state[h] = visiting
+		path = append(path, h)
+		for _, d := range h.deps {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		state[h] = visited
+		return nil
+	}
+	for _, h := range g.holders {
+		if err := visit(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
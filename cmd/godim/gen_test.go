@@ -0,0 +1,108 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testOptions(t *testing.T, dir, profile string) options {
+	ap, err := newProfile(profile)
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	return options{
+		dir:      filepath.Join("testdata", dir),
+		funcName: "declare",
+		output:   "godim_gen.go",
+		inject:   "inject",
+		config:   "config",
+		profile:  ap,
+	}
+}
+
+func TestGenerate_shouldMatchGeneratedFile(t *testing.T) {
+	src, err := generate(testOptions(t, "app", "strict"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	expected, err := os.ReadFile(filepath.Join("testdata", "app", "godim_gen.go"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if !bytes.Equal(src, expected) {
+		t.Fatalf("testdata/app/godim_gen.go is outdated, run: go run . gen -profile strict testdata/app\n%s", src)
+	}
+}
+
+func TestGenerate_shouldWireAsGodim(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the go command")
+	}
+	cmd := exec.Command("go", "run", "./app")
+	cmd.Dir = "testdata"
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Unexpected error %s: %s", err, out)
+	}
+	parts := strings.Split(string(out), "--\n")
+	if len(parts) != 2 || parts[0] != parts[1] {
+		t.Fatalf("generated code and godim should print the same, got:\n%s", out)
+	}
+	if !strings.HasPrefix(parts[0], "init Metrics\ninit DB localhost 5432 admin 4 2\ninit UserRepository users localhost UTC {Acme 100}\n") {
+		t.Fatalf("unexpected initialization order:\n%s", parts[0])
+	}
+}
+
+func TestGenerate_shouldFail(t *testing.T) {
+	tests := []struct {
+		dir     string
+		profile string
+		err     string
+	}{
+		{"cycle", "default", "dependency cycle detected: default:A -> default:B -> default:A"},
+		{"violation", "strict", "service can't be injected in repository"},
+		{"violation", "default", "repository is not a declared profile"},
+		{"section", "default", "config section tree.parent of type section.Node is recursive"},
+		{"ambiguous", "default", "ambiguous injection of Conn in field Conn of default:Repository, candidates are: default:primary, default:replica"},
+		{"lazy", "default", "default:Cache is only injected in lazy fields, its build on first use is not supported by godim gen"},
+		{"environment", "default", "WithActiveEnvironments is not supported by godim gen"},
+		{"override", "default", "Override is not supported by godim gen"},
+	}
+	for _, tt := range tests {
+		_, err := generate(testOptions(t, tt.dir, tt.profile))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: expected error %q, got %v", tt.dir, tt.err, err)
+		}
+	}
+}
+
+func TestTag_shouldMatchTheRuntimeParser(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("..", "..", "internal", "tag", "tag.go"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	cp, err := os.ReadFile(filepath.Join("internal", "tag", "tag.go"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if !bytes.Equal(src, cp) {
+		t.Fatal("internal/tag/tag.go is outdated, run: go generate")
+	}
+}
+
+func TestIdentifier(t *testing.T) {
+	if id := identifier("primary-db", true); id != "PrimaryDb" {
+		t.Fatalf("expected PrimaryDb, got %s", id)
+	}
+	if id := identifier("Handler", false); id != "handler" {
+		t.Fatalf("expected handler, got %s", id)
+	}
+}
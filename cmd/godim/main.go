@@ -0,0 +1,49 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Command godim is the godim command line.
+//
+// The gen command writes the wiring of the services declared in a function as plain Go code:
+//
+//	//go:generate godim gen -func declare -profile strict
+//
+// The generated code does the configuration, injection, initialization and closing phases without reflection,
+// so that a wrong injection is a compile error instead of a panic at startup.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `usage: godim <command> [arguments]
+
+The commands are:
+
+	gen	generate the wiring code of a declaration function
+
+Use "godim <command> -h" for more information about a command.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	var err error
+	switch os.Args[1] {
+	case "gen":
+		err = runGen(os.Args[2:])
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "godim: unknown command %q\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "godim %s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
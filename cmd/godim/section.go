@@ -0,0 +1,141 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"go/types"
+	"reflect"
+)
+
+// sectionType returns the struct of a config section, see godim sectionType
+func (g *generator) sectionType(typ types.Type) (*types.Struct, bool) {
+	typ = elemType(typ)
+	st, ok := typ.Underlying().(*types.Struct)
+	if !ok {
+		return nil, false
+	}
+	if named, ok := typ.(*types.Named); ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "net/url" && named.Obj().Name() == "URL" {
+		return nil, false
+	}
+	if m, _, _ := types.LookupFieldOrMethod(types.NewPointer(typ), true, nil, "UnmarshalText"); m != nil {
+		return nil, false
+	}
+	for i := 0; i < st.NumFields(); i++ {
+		if reflect.StructTag(st.Tag(i)).Get(g.config) != "" {
+			return st, true
+		}
+	}
+	return nil, false
+}
+
+// configFields returns the config fields of st, struct sections are expanded into the fields they hold.
+//
+// field and key prefix the names and keys of the fields, path holds the sections being expanded
+func (g *generator) configFields(st *types.Struct, field, key string, path []*types.Struct) ([]configField, error) {
+	var configs []configField
+	for i := 0; i < st.NumFields(); i++ {
+		f := st.Field(i)
+		ctag := reflect.StructTag(st.Tag(i)).Get(g.config)
+		if ctag == "" {
+			continue
+		}
+		ct, err := parseConfigTag(ctag)
+		if err != nil {
+			return nil, err
+		}
+		ct.key = key + ct.key
+		name := field + f.Name()
+		if !f.Exported() {
+			return nil, fmt.Errorf("config key %s: field %s is not exported", ct.key, name)
+		}
+		sec, ok := g.sectionType(f.Type())
+		if !ok {
+			if err := g.checkElements(f.Type(), ct, path); err != nil {
+				return nil, err
+			}
+			configs = append(configs, configField{field: name, typ: f.Type(), configTag: ct})
+			continue
+		}
+		for _, p := range path {
+			if p == sec {
+				return nil, fmt.Errorf("config section %s of type %s is recursive", ct.key, types.TypeString(elemType(f.Type()), (*types.Package).Name))
+			}
+		}
+		if ct.required || ct.hasDefault {
+			return nil, fmt.Errorf("config section %s can't be required or have a default, set them on its fields", ct.key)
+		}
+		if _, ok := f.Type().(*types.Pointer); ok {
+			configs = append(configs, configField{field: name, typ: f.Type(), alloc: true})
+		}
+		sub, err := g.configFields(sec, name+".", ct.key+".", append(path, sec))
+		if err != nil {
+			return nil, err
+		}
+		configs = append(configs, sub...)
+	}
+	return configs, nil
+}
+
+// checkElements validates the config tags of the sections held by maps and slices, filled by godim.ConvertValue
+func (g *generator) checkElements(typ types.Type, ct configTag, path []*types.Struct) error {
+	for {
+		switch t := typ.Underlying().(type) {
+		case *types.Map:
+			typ = t.Elem()
+			continue
+		case *types.Slice:
+			typ = t.Elem()
+			continue
+		case *types.Array:
+			typ = t.Elem()
+			continue
+		}
+		break
+	}
+	sec, ok := g.sectionType(typ)
+	if !ok {
+		return nil
+	}
+	for _, p := range path {
+		if p == sec {
+			return nil
+		}
+	}
+	if g.config != "config" {
+		return fmt.Errorf("config key %s: godim.ConvertValue fills %s with config tags, not %s tags", ct.key, g.typeString(typ), g.config)
+	}
+	path = append(path, sec)
+	for i := 0; i < sec.NumFields(); i++ {
+		ctag := reflect.StructTag(sec.Tag(i)).Get(g.config)
+		if ctag == "" {
+			continue
+		}
+		fct, err := parseConfigTag(ctag)
+		if err != nil {
+			return err
+		}
+		fct.key = ct.key + "." + fct.key
+		sub, ok := g.sectionType(sec.Field(i).Type())
+		if !ok {
+			if err := g.checkElements(sec.Field(i).Type(), fct, path); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := g.configFields(sub, "", fct.key+".", append(path, sub)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// elemType returns the type pointed by typ, or typ
+func elemType(typ types.Type) types.Type {
+	if p, ok := typ.(*types.Pointer); ok {
+		return p.Elem()
+	}
+	return typ
+}
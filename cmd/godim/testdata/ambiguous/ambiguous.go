@@ -0,0 +1,27 @@
+package ambiguous
+
+import "github.com/ekino/godim"
+
+type DB struct {
+	name string
+}
+
+type Primary struct{ DB }
+
+func (p *Primary) Key() string { return "primary" }
+
+type Replica struct{ DB }
+
+func (r *Replica) Key() string { return "replica" }
+
+type Conn interface {
+	Key() string
+}
+
+type Repository struct {
+	Conn Conn `inject:""`
+}
+
+func declare(g *godim.Godim) error {
+	return g.DeclareDefault(&Primary{}, &Replica{}, &Repository{})
+}
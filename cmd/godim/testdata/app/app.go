@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+
+	"github.com/ekino/godim"
+)
+
+type DB struct {
+	Host string `config:"db.host"`
+	Port int    `config:"db.port,default=5432"`
+	User string `config:"db.user,required"`
+	Pool *Pool  `config:"db.pool"`
+}
+
+type Pool struct {
+	Size int `config:"size,default=4"`
+	Idle int `config:"idle,required"`
+}
+
+type Tenant struct {
+	Name  string `config:"name,required"`
+	Quota int    `config:"quota,default=100"`
+}
+
+func NewDB() (*DB, error) {
+	return &DB{}, nil
+}
+
+func (db *DB) OnInit() error {
+	fmt.Println("init DB", db.Host, db.Port, db.User, db.Pool.Size, db.Pool.Idle)
+	return nil
+}
+
+func (db *DB) OnClose() error {
+	fmt.Println("close DB")
+	return nil
+}
+
+type Clock struct {
+	Zone string
+}
+
+var clock = Clock{Zone: "UTC"}
+
+type UserRepository struct {
+	DB      *DB               `inject:"driver:DB"`
+	Clock   *Clock            `inject:""`
+	Table   string            `config:"db.table,required"`
+	Tenants map[string]Tenant `config:"tenants"`
+}
+
+func (r *UserRepository) OnInit() error {
+	fmt.Println("init UserRepository", r.Table, r.DB.Host, r.Clock.Zone, r.Tenants["acme"])
+	return nil
+}
+
+func (r *UserRepository) OnClose() error {
+	fmt.Println("close UserRepository")
+	return nil
+}
+
+type UserService struct {
+	Repo  *UserRepository        `inject:"repository:users"`
+	Cache func() *UserRepository `inject:"repository:users"`
+	Mail  *UserRepository        `inject:"repository:Mailer,optional"`
+}
+
+func (s *UserService) Key() string { return "users" }
+
+func (s *UserService) OnInit() error {
+	fmt.Println("init UserService", s.Cache() == s.Repo, s.Mail == nil)
+	return nil
+}
+
+func (r *UserRepository) Key() string { return "users" }
+
+type UserHandler struct {
+	Service *UserService `inject:"service:users"`
+	Path    string
+}
+
+func (h *UserHandler) Priority() int { return -1 }
+
+func (h *UserHandler) OnClose() error {
+	fmt.Println("close UserHandler", h.Path)
+	return nil
+}
+
+type Metrics struct{}
+
+func (m *Metrics) OnInit() error {
+	fmt.Println("init Metrics")
+	return nil
+}
+
+func declare(g *godim.Godim) error {
+	if err := g.Declare("driver", NewDB); err != nil {
+		return err
+	}
+	if err := g.DeclareValue("driver", "clock", &clock); err != nil {
+		return err
+	}
+	if err := g.Declare("repository", &UserRepository{}); err != nil {
+		return err
+	}
+	if err := g.Declare("service", &UserService{}); err != nil {
+		return err
+	}
+	return g.Declare("handler", &UserHandler{Path: "/users"}, &Metrics{})
+}
+
+var values = map[string]interface{}{
+	"db.host":      "localhost",
+	"db.table":     "users",
+	"db.user":      "admin",
+	"db.pool.idle": 2,
+	"tenants": map[string]interface{}{
+		"acme": map[string]interface{}{"name": "Acme"},
+	},
+}
+
+func conf(key string, val reflect.Value) (interface{}, error) {
+	return values[key], nil
+}
+
+// main wires the services with the generated code, then with godim, both must print the same
+func main() {
+	app, err := newDeclareApp(conf)
+	check(err)
+	check(app.Close())
+
+	fmt.Println("--")
+	g := godim.NewConfig().WithAppProfile(godim.StrictHTTPAppProfile()).WithConfigurationFunction(conf).Build()
+	check(declare(g))
+	check(g.RunApp())
+	check(g.CloseApp())
+}
+
+func check(err error) {
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
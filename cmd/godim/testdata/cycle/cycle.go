@@ -0,0 +1,15 @@
+package cycle
+
+import "github.com/ekino/godim"
+
+type A struct {
+	B *B `inject:"B"`
+}
+
+type B struct {
+	A *A `inject:"A"`
+}
+
+func declare(g *godim.Godim) error {
+	return g.DeclareDefault(&A{}, &B{})
+}
@@ -0,0 +1,15 @@
+package environment
+
+import "github.com/ekino/godim"
+
+type Handler struct {
+	Path string `config:"handler.path"`
+}
+
+func declare(g *godim.Godim) error {
+	return g.DeclareDefault(&Handler{})
+}
+
+func build() *godim.Godim {
+	return godim.NewConfig().WithActiveEnvironments("prod").Build()
+}
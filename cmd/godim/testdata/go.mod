@@ -0,0 +1,7 @@
+module example.com/gen
+
+go 1.20
+
+require github.com/ekino/godim v0.0.0
+
+replace github.com/ekino/godim => ../../..
@@ -0,0 +1,17 @@
+package lazy
+
+import "github.com/ekino/godim"
+
+type Cache struct{}
+
+func NewCache() *Cache {
+	return &Cache{}
+}
+
+type Handler struct {
+	Cache func() *Cache `inject:"Cache"`
+}
+
+func declare(g *godim.Godim) error {
+	return g.DeclareDefault(NewCache, &Handler{})
+}
@@ -0,0 +1,14 @@
+package override
+
+import "github.com/ekino/godim"
+
+type Store struct{}
+
+type FakeStore struct{}
+
+func declare(g *godim.Godim) error {
+	if err := g.DeclareDefault(&Store{}); err != nil {
+		return err
+	}
+	return g.Override("default", "Store", &FakeStore{})
+}
@@ -0,0 +1,16 @@
+package section
+
+import "github.com/ekino/godim"
+
+type Node struct {
+	Name   string `config:"name"`
+	Parent *Node  `config:"parent"`
+}
+
+type Tree struct {
+	Root Node `config:"tree"`
+}
+
+func declare(g *godim.Godim) error {
+	return g.DeclareDefault(&Tree{})
+}
@@ -0,0 +1,13 @@
+package violation
+
+import "github.com/ekino/godim"
+
+type Service struct{}
+
+type Repository struct {
+	Service *Service `inject:"service:Service"`
+}
+
+func declare(g *godim.Godim) error {
+	return g.Declare("repository", &Repository{})
+}
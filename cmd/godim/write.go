@@ -0,0 +1,324 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/types"
+	pathpkg "path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// qualifier names the imports of the generated file, registering them on first use
+func (g *generator) qualifier(pkg *types.Package) string {
+	if pkg == g.pkg.Types {
+		return ""
+	}
+	return g.importName(pkg.Path(), pkg.Name())
+}
+
+func (g *generator) importName(path, name string) string {
+	if n, ok := g.imports[path]; ok {
+		return n
+	}
+	n := name
+	for i := 2; g.importOf[n] != "" || g.pkg.Types.Scope().Lookup(n) != nil; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	g.imports[path] = n
+	g.importOf[n] = path
+	return n
+}
+
+func (g *generator) typeString(typ types.Type) string {
+	return types.TypeString(typ, g.qualifier)
+}
+
+// write returns the formatted source of the generated file
+func (g *generator) write(order []*holder) ([]byte, error) {
+	app, newApp, configure := g.appName(), g.newAppName(), g.configureName()
+	godim := g.importName(godimPath, "godim")
+	fmtPkg := g.importName("fmt", "fmt")
+	reflectPkg := g.importName("reflect", "reflect")
+
+	var body bytes.Buffer
+	p := func(format string, args ...interface{}) {
+		fmt.Fprintf(&body, format+"\n", args...)
+	}
+	p("// %s holds the services declared in %s", app, g.funcName)
+	p("type %s struct {", app)
+	for _, h := range g.holders {
+		p("%s %s", h.name, g.typeString(h.typ))
+	}
+	p("initialized []struct {")
+	p("key string")
+	p("onClose func() error")
+	p("}")
+	p("}")
+	p("")
+	p("// %s runs the configuration, injection and initialization phases of the services declared in %s.", newApp, g.funcName)
+	p("//")
+	p("// conf is the configuration function, as given to godim.Config.WithConfigurationFunction, it can be nil.")
+	p("// The services are closed with Close.")
+	p("func %s(conf func(key string, val %s.Value) (interface{}, error)) (*%s, error) {", newApp, reflectPkg, app)
+	p("app := &%s{}", app)
+	if g.needsErr() {
+		p("var err error")
+	}
+	if g.hasConfigs(false) || g.hasConfigs(true) {
+		p("missing := &%s.MultiError{}", godim)
+	}
+	p("// declaration")
+	for _, h := range g.holders {
+		if h.provider == nil {
+			p("app.%s = %s", h.name, h.value)
+		}
+	}
+	if g.hasConfigs(false) || g.hasRequired() {
+		p("// configuration")
+		for _, h := range g.holders {
+			if h.provider == nil {
+				g.writeConfigs(p, configure, h)
+				continue
+			}
+			// the required keys of the services built by providers are checked before injection
+			for _, c := range h.configs {
+				if c.required {
+					p("if err = %s(conf, %s, new(%s), nil, true, %s, missing); err != nil {", configure, strconv.Quote(c.key), g.typeString(c.typ), strconv.Quote(h.String()))
+					p("return nil, err")
+					p("}")
+				}
+			}
+		}
+		g.writeMissing(p, godim)
+	}
+	p("// injection")
+	for _, h := range order {
+		if h.provider == nil {
+			continue
+		}
+		var params []string
+		for _, param := range h.params {
+			params = append(params, "app."+param.name)
+		}
+		call := fmt.Sprintf("%s(%s)", g.qualified(h.provider), strings.Join(params, ", "))
+		if h.provider.Type().(*types.Signature).Results().Len() == 2 {
+			p("app.%s, err = %s", h.name, call)
+			p("if err != nil {")
+			p("return nil, err")
+			p("}")
+		} else {
+			p("app.%s = %s", h.name, call)
+		}
+		if len(h.configs) > 0 {
+			g.writeConfigs(p, configure, h)
+			g.writeMissing(p, godim)
+		}
+	}
+	for _, h := range g.holders {
+		for _, in := range h.injects {
+			if in.lazy != nil {
+				p("app.%s.%s = func() %s {", h.name, in.field, g.typeString(in.lazy.Results().At(0).Type()))
+				p("return app.%s", in.dep.name)
+				p("}")
+			} else {
+				p("app.%s.%s = app.%s", h.name, in.field, in.dep.name)
+			}
+		}
+	}
+	p("// initialization")
+	for _, h := range order {
+		if !h.onInit && !h.onClose {
+			continue
+		}
+		onInit, onClose := "nil", "nil"
+		if h.onInit {
+			onInit = "app." + h.name + ".OnInit"
+		}
+		if h.onClose {
+			onClose = "app." + h.name + ".OnClose"
+		}
+		p("if err = app.initialize(%s, %s, %s); err != nil {", strconv.Quote(h.String()), onInit, onClose)
+		p("return nil, err")
+		p("}")
+	}
+	p("return app, nil")
+	p("}")
+	p("")
+	p("// initialize calls onInit, or closes the initialized services when it fails")
+	p("func (app *%s) initialize(key string, onInit, onClose func() error) error {", app)
+	p("if onInit != nil {")
+	p("if err := onInit(); err != nil {")
+	p("errs := &%s.MultiError{Errors: []%s.KeyError{{Key: key, Err: err}}}", godim, godim)
+	p("app.closeInitialized(errs)")
+	p("return &%s.Error{Err: errs, Type: %s.ErrTypeGodim}", godim, godim)
+	p("}")
+	p("}")
+	p("app.initialized = append(app.initialized, struct {")
+	p("key string")
+	p("onClose func() error")
+	p("}{key, onClose})")
+	p("return nil")
+	p("}")
+	p("")
+	p("// Close calls OnClose in the reverse order of initialization, failures are gathered in a godim.MultiError")
+	p("func (app *%s) Close() error {", app)
+	p("errs := &%s.MultiError{}", godim)
+	p("app.closeInitialized(errs)")
+	p("if len(errs.Errors) > 0 {")
+	p("return &%s.Error{Err: errs, Type: %s.ErrTypeGodim}", godim, godim)
+	p("}")
+	p("return nil")
+	p("}")
+	p("")
+	p("func (app *%s) closeInitialized(errs *%s.MultiError) {", app, godim)
+	p("for i := len(app.initialized) - 1; i >= 0; i-- {")
+	p("if c := app.initialized[i]; c.onClose != nil {")
+	p("if err := c.onClose(); err != nil {")
+	p("errs.Errors = append(errs.Errors, %s.KeyError{Key: c.key, Err: err})", godim)
+	p("}")
+	p("}")
+	p("}")
+	p("app.initialized = nil")
+	p("}")
+	p("")
+	p("// %s sets the value returned by conf for key in field, converted with godim.ConvertValue.", configure)
+	p("//")
+	p("// conf can be nil. When key has no value, def is used if it is not nil,")
+	p("// a required key is added to missing for service, otherwise field is left unchanged.")
+	p("func %s[T any](conf func(key string, val %s.Value) (interface{}, error), key string, field *T, def interface{}, required bool, service string, missing *%s.MultiError) error {", configure, reflectPkg, godim)
+	p("var v interface{}")
+	p("var err error")
+	p("if conf != nil {")
+	p("v, err = conf(key, %s.ValueOf(field).Elem())", reflectPkg)
+	p("}")
+	p("if (err != nil || v == nil) && (def != nil || required) {")
+	p("if required {")
+	p("if err != nil {")
+	p("err = %s.Errorf(\"required config key has no value: %%s (%%s)\", key, err)", fmtPkg)
+	p("} else {")
+	p("err = %s.Errorf(\"required config key has no value: %%s\", key)", fmtPkg)
+	p("}")
+	p("missing.Errors = append(missing.Errors, %s.KeyError{Key: service, Err: err})", godim)
+	p("return nil")
+	p("}")
+	p("v, err = def, nil")
+	p("}")
+	p("if err != nil || v == nil {")
+	p("return err")
+	p("}")
+	p("t, ok := v.(T)")
+	p("if !ok {")
+	p("typ := %s.TypeOf(field).Elem()", reflectPkg)
+	p("cv, err := %s.ConvertValue(v, typ)", godim)
+	p("if err != nil {")
+	p("return &%s.Error{Err: %s.Errorf(\"config key %%s: can't set %%T in a field of type %%s: %%w\", key, v, typ, err), Type: %s.ErrTypeRegistry}", godim, fmtPkg, godim)
+	p("}")
+	p("t = cv.(T)")
+	p("}")
+	p("*field = t")
+	p("return nil")
+	p("}")
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by godim gen from %s. DO NOT EDIT.\n\n", g.funcName)
+	fmt.Fprintf(&out, "package %s\n\n", g.pkg.Name)
+	paths := make([]string, 0, len(g.imports))
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	out.WriteString("import (\n")
+	for _, std := range []bool{true, false} {
+		for _, path := range paths {
+			if isStd(path) != std {
+				continue
+			}
+			if name := g.imports[path]; name != pathpkg.Base(path) {
+				fmt.Fprintf(&out, "%s ", name)
+			}
+			fmt.Fprintf(&out, "%s\n", strconv.Quote(path))
+		}
+		out.WriteString("\n")
+	}
+	out.WriteString(")\n\n")
+	out.Write(body.Bytes())
+	return format.Source(out.Bytes())
+}
+
+func (g *generator) hasConfigs(providers bool) bool {
+	for _, h := range g.holders {
+		if (h.provider != nil) == providers && len(h.configs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// hasRequired tells if a service built by a provider has a required config key
+func (g *generator) hasRequired() bool {
+	for _, h := range g.holders {
+		for _, c := range h.configs {
+			if h.provider != nil && c.required {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (g *generator) writeConfigs(p func(string, ...interface{}), configure string, h *holder) {
+	for _, c := range h.configs {
+		if c.alloc {
+			p("if app.%s.%s == nil {", h.name, c.field)
+			p("app.%s.%s = new(%s)", h.name, c.field, g.typeString(c.typ.(*types.Pointer).Elem()))
+			p("}")
+			continue
+		}
+		def := "nil"
+		if c.hasDefault {
+			def = strconv.Quote(c.def)
+		}
+		p("if err = %s(conf, %s, &app.%s.%s, %s, %t, %s, missing); err != nil {", configure, strconv.Quote(c.key), h.name, c.field, def, c.required, strconv.Quote(h.String()))
+		p("return nil, err")
+		p("}")
+	}
+}
+
+// writeMissing returns the required config keys without value, gathered by the configure calls
+func (g *generator) writeMissing(p func(string, ...interface{}), godim string) {
+	p("if len(missing.Errors) > 0 {")
+	p("return nil, &%s.Error{Err: missing, Type: %s.ErrTypeRegistry}", godim, godim)
+	p("}")
+}
+
+func (g *generator) qualified(fn *types.Func) string {
+	if q := g.qualifier(fn.Pkg()); q != "" {
+		return q + "." + fn.Name()
+	}
+	return fn.Name()
+}
+
+// needsErr tells if the generated constructor uses an err variable
+func (g *generator) needsErr() bool {
+	for _, h := range g.holders {
+		if len(h.configs) > 0 || h.onInit || h.onClose {
+			return true
+		}
+		if h.provider != nil && h.provider.Type().(*types.Signature).Results().Len() == 2 {
+			return true
+		}
+	}
+	return false
+}
+
+// isStd tells if path is a standard library package, imported before the others
+func isStd(path string) bool {
+	return !strings.Contains(strings.Split(path, "/")[0], ".")
+}
@@ -0,0 +1,174 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// Condition decides whether the services given to DeclareIf are declared.
+//
+// Conditions are evaluated at the end of the configuration phase, before the injection phase,
+// in declaration order, so a condition sees the conditional services kept before it
+type Condition struct {
+	desc    string
+	matches func(registry *Registry) (bool, error)
+}
+
+// String describes the condition
+func (c Condition) String() string {
+	return c.desc
+}
+
+// OnConfig holds when the configuration function returns a non zero value for key.
+//
+// The configuration function is called with a string val, as for a string field.
+// An error returned by the configuration function means the key is not set
+func OnConfig(key string) Condition {
+	return Condition{
+		desc: fmt.Sprintf("config %s is set", key),
+		matches: func(registry *Registry) (bool, error) {
+			v, ok := registry.configValue(key)
+			return ok && v != nil && !reflect.ValueOf(v).IsZero(), nil
+		},
+	}
+}
+
+// OnConfigValue holds when the configuration function returns value for key, like a feature flag.
+//
+// The configuration function is called with a string val, see OnConfig
+func OnConfigValue(key string, value interface{}) Condition {
+	return Condition{
+		desc: fmt.Sprintf("config %s is %v", key, value),
+		matches: func(registry *Registry) (bool, error) {
+			v, ok := registry.configValue(key)
+			return ok && reflect.DeepEqual(v, value), nil
+		},
+	}
+}
+
+// OnPresent holds when a service is declared under label and key, in this Godim or its parent
+func OnPresent(label, key string) Condition {
+	return Condition{
+		desc: fmt.Sprintf("%s:%s is present", label, key),
+		matches: func(registry *Registry) (bool, error) {
+			return registry.getHolder(label, key) != nil, nil
+		},
+	}
+}
+
+// OnMissing holds when no service is declared under label and key, in this Godim or its parent
+func OnMissing(label, key string) Condition {
+	return Condition{
+		desc: fmt.Sprintf("%s:%s is missing", label, key),
+		matches: func(registry *Registry) (bool, error) {
+			return registry.getHolder(label, key) == nil, nil
+		},
+	}
+}
+
+// Not holds when c does not
+func Not(c Condition) Condition {
+	return Condition{
+		desc: fmt.Sprintf("not (%s)", c),
+		matches: func(registry *Registry) (bool, error) {
+			ok, err := c.matches(registry)
+			return !ok, err
+		},
+	}
+}
+
+// conditional is a declaration waiting for its condition to be evaluated
+type conditional struct {
+	cond  Condition
+	label string
+	o     []interface{}
+	// module is the name of the module which declared o, if any
+	module string
+}
+
+// declareIf keeps o to be declared in label if cond holds at the end of the configuration phase
+func (registry *Registry) declareIf(cond Condition, label string, o []interface{}) error {
+	if cond.matches == nil {
+		return newError(fmt.Errorf("no condition given for %s", label)).SetErrType(ErrTypeRegistry)
+	}
+	if !registry.appProfile.validate(label) {
+		return newError(fmt.Errorf(" %s is not a declared profile", label)).SetErrType(ErrTypeRegistry)
+	}
+	for _, v := range o {
+		if v == nil {
+			return newError(fmt.Errorf("can't declare a nil value in %s", label)).SetErrType(ErrTypeRegistry)
+		}
+	}
+	registry.conditionals = append(registry.conditionals, &conditional{cond: cond, label: label, o: o})
+	return nil
+}
+
+// declareConditionals declares the services whose condition holds and configures them,
+// the required keys without value are added to missing
+func (registry *Registry) declareConditionals(missing *MultiError) error {
+	for _, c := range registry.conditionals {
+		ok, err := c.cond.matches(registry)
+		if err != nil {
+			return newError(fmt.Errorf("condition %s: %w", c.cond, err)).SetErrType(ErrTypeRegistry)
+		}
+		decision := fmt.Sprintf("%s %s in %s: %s", typeNames(c.o), declaredOrSkipped(ok), c.label, c.cond)
+		registry.decisions = append(registry.decisions, decision)
+		log.Printf("[Godim] %s\n", decision)
+		if !ok {
+			continue
+		}
+		declared := len(registry.holders)
+		for _, o := range c.o {
+			err := registry.declare(c.label, o)
+			if err != nil {
+				return err
+			}
+		}
+		for _, h := range registry.holders[declared:] {
+			h.module = c.module
+			err := registry.configureDeclared(h, missing)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	registry.conditionals = nil
+	return nil
+}
+
+// configValue returns the value of key given by the configuration function, false if it has none
+func (registry *Registry) configValue(key string) (interface{}, bool) {
+	if registry.configFunc == nil {
+		return nil, false
+	}
+	// the key is read as for a string field, the configuration function may switch on the kind of val
+	var v string
+	res, err := registry.configFunc(key, reflect.ValueOf(&v).Elem())
+	if err != nil {
+		return nil, false
+	}
+	return res, true
+}
+
+func declaredOrSkipped(ok bool) string {
+	if ok {
+		return "declared"
+	}
+	return "skipped"
+}
+
+func typeNames(o []interface{}) string {
+	s := ""
+	for i, v := range o {
+		if i > 0 {
+			s += ", "
+		}
+		s += reflect.TypeOf(v).String()
+	}
+	return s
+}
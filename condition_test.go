@@ -0,0 +1,230 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type Cache interface {
+	Name() string
+}
+
+type RedisCache struct {
+	URL string `config:"redis.url"`
+}
+
+func (rc *RedisCache) Name() string {
+	return "redis " + rc.URL
+}
+
+type MemCache struct{}
+
+func (mc *MemCache) Name() string {
+	return "memory"
+}
+
+type CacheUser struct {
+	Cache Cache `inject:""`
+}
+
+func mapConfig(values map[string]interface{}) func(key string, val reflect.Value) (interface{}, error) {
+	return func(key string, val reflect.Value) (interface{}, error) {
+		v, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %s", key)
+		}
+		return v, nil
+	}
+}
+
+func runCacheApp(t *testing.T, values map[string]interface{}) (*Godim, *CacheUser) {
+	g := NewConfig().WithConfigurationFunction(mapConfig(values)).Build()
+	cu := &CacheUser{}
+	err := g.DeclareDefault(cu)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareIf(OnConfig("redis.url"), defaultStr, &RedisCache{})
+	if err != nil {
+		t.Fatalf("Error while declaring redis: %s.", err)
+	}
+	err = g.DeclareIf(Not(OnConfig("redis.url")), defaultStr, &MemCache{})
+	if err != nil {
+		t.Fatalf("Error while declaring memory cache: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	return g, cu
+}
+
+func TestGodim_DeclareIf_shouldDeclareWhenConditionHolds(t *testing.T) {
+	g, cu := runCacheApp(t, map[string]interface{}{"redis.url": "redis://cache"})
+	if cu.Cache == nil || cu.Cache.Name() != "redis redis://cache" {
+		t.Fatalf("configured redis cache should be injected, got %v", cu.Cache)
+	}
+	decisions := g.Conditions()
+	if len(decisions) != 2 {
+		t.Fatalf("expected 2 decisions, got %v", decisions)
+	}
+	if decisions[0] != "*godim.RedisCache declared in default: config redis.url is set" {
+		t.Fatalf("unexpected decision %s", decisions[0])
+	}
+	if decisions[1] != "*godim.MemCache skipped in default: not (config redis.url is set)" {
+		t.Fatalf("unexpected decision %s", decisions[1])
+	}
+}
+
+func TestGodim_DeclareIf_shouldSkipWhenConditionFails(t *testing.T) {
+	g, cu := runCacheApp(t, map[string]interface{}{})
+	if cu.Cache == nil || cu.Cache.Name() != "memory" {
+		t.Fatalf("memory cache should be injected, got %v", cu.Cache)
+	}
+	if g.GetStruct(defaultStr, "RedisCache") != nil {
+		t.Fatal("redis cache should not be declared")
+	}
+}
+
+type Mailer struct {
+	mock bool
+}
+
+type MailUser struct {
+	Mailer *Mailer `inject:"default:Mailer"`
+}
+
+func TestGodim_DeclareIf_shouldSeeServicesDeclaredBefore(t *testing.T) {
+	g := Default()
+	err := g.DeclareIf(OnMissing(defaultStr, "Mailer"), defaultStr, &Mailer{mock: true})
+	if err != nil {
+		t.Fatalf("Error while declaring mock mailer: %s.", err)
+	}
+	mu := &MailUser{}
+	err = g.DeclareIf(OnPresent(defaultStr, "Mailer"), defaultStr, mu)
+	if err != nil {
+		t.Fatalf("Error while declaring mail user: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if mu.Mailer == nil || !mu.Mailer.mock {
+		t.Fatalf("mock mailer should be injected, got %v", mu.Mailer)
+	}
+}
+
+func TestGodim_DeclareIf_shouldFailOutsideDeclaration(t *testing.T) {
+	g := NewConfig().WithAppProfile(StrictHTTPAppProfile()).Build()
+	err := g.DeclareIf(OnConfig("a"), "controller", &Mailer{})
+	if err == nil || !strings.Contains(err.Error(), "controller is not a declared profile") {
+		t.Fatalf("undeclared profile should be rejected, got %v", err)
+	}
+	err = g.DeclareIf(Condition{}, "service", &Mailer{})
+	if err == nil {
+		t.Fatal("an empty condition should be rejected")
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	err = g.DeclareIf(OnConfig("a"), "service", &Mailer{})
+	if err == nil {
+		t.Fatal("DeclareIf should fail once the app is running")
+	}
+}
+
+// kindConfig converts the values to the kind of the field, like a config read from the environment
+func kindConfig(values map[string]string) func(key string, val reflect.Value) (interface{}, error) {
+	return func(key string, val reflect.Value) (interface{}, error) {
+		s, ok := values[key]
+		if !ok {
+			return nil, nil
+		}
+		switch val.Kind() {
+		case reflect.String:
+			return s, nil
+		case reflect.Bool:
+			return strconv.ParseBool(s)
+		}
+		return nil, fmt.Errorf("unsupported kind %s for key %s", val.Kind(), key)
+	}
+}
+
+func TestGodim_DeclareIf_shouldReadConfigAsString(t *testing.T) {
+	g := NewConfig().WithConfigurationFunction(kindConfig(map[string]string{"redis.url": "redis://cache", "mail.mode": "mock"})).Build()
+	cu := &CacheUser{}
+	err := g.DeclareDefault(cu)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareIf(OnConfig("redis.url"), defaultStr, &RedisCache{})
+	if err != nil {
+		t.Fatalf("Error while declaring redis: %s.", err)
+	}
+	err = g.DeclareIf(OnConfigValue("mail.mode", "mock"), defaultStr, &Mailer{mock: true})
+	if err != nil {
+		t.Fatalf("Error while declaring mock mailer: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if cu.Cache == nil || cu.Cache.Name() != "redis redis://cache" {
+		t.Fatalf("OnConfig should hold for a kind sensitive configuration function, got %v", cu.Cache)
+	}
+	if g.GetStruct(defaultStr, "Mailer") == nil {
+		t.Fatalf("OnConfigValue should hold for a kind sensitive configuration function, got %v", g.Conditions())
+	}
+}
+
+type RequiredSettings struct {
+	A string `config:"settings.a,required"`
+}
+
+type ConditionalSettings struct {
+	B string `config:"settings.b,required"`
+}
+
+type ProvidedSettings struct {
+	C string `config:"settings.c,required"`
+}
+
+func TestGodim_DeclareIf_shouldReportMissingKeysTogether(t *testing.T) {
+	g := NewConfig().WithConfigurationFunction(mapConfig(map[string]interface{}{"feature": "on"})).Build()
+	err := g.DeclareDefault(&RequiredSettings{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareIf(OnConfig("feature"), defaultStr, &ConditionalSettings{})
+	if err != nil {
+		t.Fatalf("Error while declaring conditional settings: %s.", err)
+	}
+	called := false
+	err = g.DeclareIf(OnConfig("feature"), defaultStr, func() *ProvidedSettings {
+		called = true
+		return &ProvidedSettings{}
+	})
+	if err != nil {
+		t.Fatalf("Error while declaring provided settings: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("RunApp should fail on missing required keys")
+	}
+	for _, key := range []string{"settings.a", "settings.b", "settings.c"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("missing %s should be reported, got %s", key, err)
+		}
+	}
+	if called {
+		t.Fatal("no provider should be called when required keys are missing")
+	}
+}
@@ -19,6 +19,7 @@ type Config struct {
 	activateES     bool
 	bufferSize     int
 	eventSwitch    *EventSwitch
+	environments   []string
 }
 
 // NewConfig declare a new config
@@ -76,6 +77,27 @@ func (c *Config) WithConfigurationFunction(f func(key string, val reflect.Value)
 	return c
 }
 
+// ConfigurationFunction returns the configuration function given to WithConfigurationFunction, if any
+func (c *Config) ConfigurationFunction() func(key string, val reflect.Value) (interface{}, error) {
+	return c.configFunction
+}
+
+// WithActiveEnvironments declare the runtime environments, like dev, test or prod.
+//
+// Config keys prefixed by an active environment, like prod.db.host, are looked up first,
+// the last environment first, and declarations can depend on them with OnEnvironment
+func (c *Config) WithActiveEnvironments(envs ...string) *Config {
+	for _, env := range envs {
+		e := strings.TrimSpace(env)
+		if len(e) > 0 {
+			c.environments = append(c.environments, e)
+		} else {
+			log.Printf("Environment %s ignored", env)
+		}
+	}
+	return c
+}
+
 // WithEventSwitch start an event switch with godim
 func (c *Config) WithEventSwitch(bufferSize int) *Config {
 	c.activateES = true
@@ -94,3 +116,11 @@ func (c *Config) Build() *Godim {
 	}
 	return NewGodim(c)
 }
+
+// BuildChild lock profile and build a child godim of parent with this configuration, see NewChild
+func (c *Config) BuildChild(parent *Godim) *Godim {
+	g := c.Build()
+	g.parent = parent
+	g.registry.parent = parent.registry
+	return g
+}
@@ -0,0 +1,310 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"encoding"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	durationType        = reflect.TypeOf(time.Duration(0))
+	urlType             = reflect.TypeOf(url.URL{})
+)
+
+// ConvertValue converts a value given by a configuration function to typ, as done in the configuration phase.
+//
+// Values assignable to typ are kept, strings are parsed as numbers, booleans, durations, url.URL
+// or with the UnmarshalText method of typ, and set as is in strings and byte slices.
+// Comma separated strings and slices fill other slices element by element,
+// numbers are converted to other number types when they fit.
+// Maps fill maps entry by entry, and structs through the config tags of their fields, as a config section.
+func ConvertValue(v interface{}, typ reflect.Type) (interface{}, error) {
+	rv, err := convertValue(v, typ, defaultConfig)
+	if err != nil {
+		return nil, err
+	}
+	return rv.Interface(), nil
+}
+
+// convertValue converts v to typ, tag is the name of the config tag of the structs filled from maps
+func convertValue(v interface{}, typ reflect.Type, tag string) (reflect.Value, error) {
+	if v == nil {
+		return reflect.Value{}, fmt.Errorf("can't convert nil to %s", typ)
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Type().AssignableTo(typ) {
+		return rv, nil
+	}
+	if b, ok := v.([]byte); ok {
+		v, rv = string(b), reflect.ValueOf(string(b))
+	}
+	if s, ok := v.(string); ok {
+		return convertString(s, typ, tag)
+	}
+	switch {
+	case rv.Kind() == reflect.Slice || rv.Kind() == reflect.Array:
+		if typ.Kind() == reflect.Slice {
+			return convertSlice(rv, typ, tag)
+		}
+	case rv.Kind() == reflect.Map && typ.Kind() == reflect.Map:
+		return convertMap(rv, typ, tag)
+	case rv.Kind() == reflect.Map && typ.Kind() == reflect.Struct:
+		return convertStruct(rv, typ, tag)
+	case typ.Kind() == reflect.Ptr:
+		e, err := convertValue(v, typ.Elem(), tag)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		p := reflect.New(typ.Elem())
+		p.Elem().Set(e)
+		return p, nil
+	case isNumber(rv.Kind()) && isNumber(typ.Kind()):
+		return convertNumber(rv, typ)
+	case rv.Kind() == reflect.Bool && typ.Kind() == reflect.Bool:
+		return rv.Convert(typ), nil
+	}
+	return reflect.Value{}, fmt.Errorf("can't convert %T to %s", v, typ)
+}
+
+func convertString(s string, typ reflect.Type, tag string) (reflect.Value, error) {
+	if ok, rv, err := unmarshalText(s, typ); ok {
+		return rv, err
+	}
+	switch {
+	case typ == durationType:
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		return reflect.ValueOf(d), nil
+	case typ == urlType, typ.Kind() == reflect.Ptr && typ.Elem() == urlType:
+		u, err := url.Parse(strings.TrimSpace(s))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		if typ == urlType {
+			return reflect.ValueOf(*u), nil
+		}
+		return reflect.ValueOf(u), nil
+	}
+	rv := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.String:
+		rv.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(strings.TrimSpace(s))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(strings.TrimSpace(s), 0, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		u, err := strconv.ParseUint(strings.TrimSpace(s), 0, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(strings.TrimSpace(s), typ.Bits())
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		rv.SetFloat(f)
+	case reflect.Slice:
+		if typ.Elem().Kind() == reflect.Uint8 {
+			// a string is the content of a byte slice, not a list
+			return reflect.ValueOf([]byte(s)).Convert(typ), nil
+		}
+		if strings.TrimSpace(s) == "" {
+			return reflect.MakeSlice(typ, 0, 0), nil
+		}
+		// the spaces around the commas separate the elements
+		parts := strings.Split(s, ",")
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+		return convertSlice(reflect.ValueOf(parts), typ, tag)
+	default:
+		return reflect.Value{}, fmt.Errorf("can't convert string %q to %s", s, typ)
+	}
+	return rv, nil
+}
+
+// unmarshalText uses the UnmarshalText method of typ or of the type it points to, ok is false if there is none
+func unmarshalText(s string, typ reflect.Type) (bool, reflect.Value, error) {
+	switch {
+	case reflect.PtrTo(typ).Implements(textUnmarshalerType):
+		p := reflect.New(typ)
+		err := p.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return true, p.Elem(), err
+	case typ.Kind() == reflect.Ptr && typ.Implements(textUnmarshalerType):
+		p := reflect.New(typ.Elem())
+		err := p.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		return true, p, err
+	}
+	return false, reflect.Value{}, nil
+}
+
+func convertSlice(rv reflect.Value, typ reflect.Type, tag string) (reflect.Value, error) {
+	out := reflect.MakeSlice(typ, rv.Len(), rv.Len())
+	for i := 0; i < rv.Len(); i++ {
+		e, err := convertValue(rv.Index(i).Interface(), typ.Elem(), tag)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("element %d: %w", i, err)
+		}
+		out.Index(i).Set(e)
+	}
+	return out, nil
+}
+
+func convertMap(rv reflect.Value, typ reflect.Type, tag string) (reflect.Value, error) {
+	out := reflect.MakeMapWithSize(typ, rv.Len())
+	iter := rv.MapRange()
+	for iter.Next() {
+		k, err := convertValue(iter.Key().Interface(), typ.Key(), tag)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("key %v: %w", iter.Key(), err)
+		}
+		e, err := convertValue(iter.Value().Interface(), typ.Elem(), tag)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("entry %v: %w", iter.Key(), err)
+		}
+		out.SetMapIndex(k, e)
+	}
+	return out, nil
+}
+
+// convertStruct fills a struct from a map, each field tagged with tag takes the entry of its key.
+//
+// Dotted keys are looked up in nested maps too, the options of the tags apply as in the configuration phase
+func convertStruct(rv reflect.Value, typ reflect.Type, tag string) (reflect.Value, error) {
+	out := reflect.New(typ).Elem()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		ctag := field.Tag.Get(tag)
+		if ctag == "" {
+			continue
+		}
+		ct, err := parseConfigTag(ctag)
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		v := lookupKey(rv, ct.key)
+		if v == nil {
+			switch {
+			case ct.required:
+				return reflect.Value{}, fmt.Errorf("%w: %s", errMissingConfig, ct.key)
+			case ct.hasDefault:
+				v = ct.def
+			default:
+				continue
+			}
+		}
+		if !out.Field(i).CanSet() {
+			return reflect.Value{}, fmt.Errorf("field %s of %s is not exported", field.Name, typ)
+		}
+		fv, err := convertValue(v, field.Type, tag)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%s: %w", ct.key, err)
+		}
+		out.Field(i).Set(fv)
+	}
+	return out, nil
+}
+
+// lookupKey returns the entry of key in the map rv, "a.b" is looked up as is then as b in the map entry a
+func lookupKey(rv reflect.Value, key string) interface{} {
+	if rv.Kind() == reflect.Interface {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Map || rv.IsNil() {
+		return nil
+	}
+	kv := reflect.ValueOf(key)
+	switch {
+	case kv.Type().AssignableTo(rv.Type().Key()):
+	case kv.Type().ConvertibleTo(rv.Type().Key()):
+		kv = kv.Convert(rv.Type().Key())
+	default:
+		return nil
+	}
+	if e := rv.MapIndex(kv); e.IsValid() && !(e.Kind() == reflect.Interface && e.IsNil()) {
+		return e.Interface()
+	}
+	if i := strings.Index(key, "."); i > 0 {
+		if e := lookupKey(rv, key[:i]); e != nil {
+			return lookupKey(reflect.ValueOf(e), key[i+1:])
+		}
+	}
+	return nil
+}
+
+func isNumber(k reflect.Kind) bool {
+	return k >= reflect.Int && k <= reflect.Float64
+}
+
+// convertNumber converts between number types, failing when the value does not fit
+func convertNumber(rv reflect.Value, typ reflect.Type) (reflect.Value, error) {
+	out := reflect.New(typ).Elem()
+	switch typ.Kind() {
+	case reflect.Float32, reflect.Float64:
+		return rv.Convert(typ), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch {
+		case rv.CanInt():
+			i = rv.Int()
+		case rv.CanUint():
+			if rv.Uint() > 1<<63-1 {
+				return reflect.Value{}, fmt.Errorf("%v overflows %s", rv, typ)
+			}
+			i = int64(rv.Uint())
+		default:
+			f := rv.Float()
+			if f != float64(int64(f)) {
+				return reflect.Value{}, fmt.Errorf("%v is not an integer", f)
+			}
+			i = int64(f)
+		}
+		if out.OverflowInt(i) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %s", rv, typ)
+		}
+		out.SetInt(i)
+	default:
+		var u uint64
+		switch {
+		case rv.CanUint():
+			u = rv.Uint()
+		case rv.CanInt():
+			if rv.Int() < 0 {
+				return reflect.Value{}, fmt.Errorf("%v is negative", rv)
+			}
+			u = uint64(rv.Int())
+		default:
+			f := rv.Float()
+			if f < 0 || f != float64(uint64(f)) {
+				return reflect.Value{}, fmt.Errorf("%v is not a positive integer", f)
+			}
+			u = uint64(f)
+		}
+		if out.OverflowUint(u) {
+			return reflect.Value{}, fmt.Errorf("%v overflows %s", rv, typ)
+		}
+		out.SetUint(u)
+	}
+	return out, nil
+}
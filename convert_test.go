@@ -0,0 +1,161 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type Level int
+
+func (l *Level) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "debug":
+		*l = 0
+	case "info":
+		*l = 1
+	default:
+		return fmt.Errorf("unknown level %s", text)
+	}
+	return nil
+}
+
+func TestConvertValue(t *testing.T) {
+	u, _ := url.Parse("https://example.com/api")
+	tests := []struct {
+		v        interface{}
+		expected interface{}
+	}{
+		{"42", 42},
+		{" 42 ", int8(42)},
+		{"0x10", uint16(16)},
+		{"1.5", 1.5},
+		{"true", true},
+		{"1m30s", 90 * time.Second},
+		{"a, b,c", []string{"a", "b", "c"}},
+		{"", []string{}},
+		{"1,2,3", []int{1, 2, 3}},
+		{[]interface{}{"1", 2.0}, []int{1, 2}},
+		{"https://example.com/api", *u},
+		{"https://example.com/api", u},
+		{"info", Level(1)},
+		{"10.0.0.1", net.ParseIP("10.0.0.1")},
+		{float64(8080), 8080},
+		{int64(3), 3.0},
+		{int(5), uint8(5)},
+		{[]byte("7"), 7},
+		{"text", "text"},
+		{" padded text ", " padded text "},
+		{"a,b", []byte("a,b")},
+		{" raw ", json.RawMessage(" raw ")},
+	}
+	for _, tt := range tests {
+		v, err := ConvertValue(tt.v, reflect.TypeOf(tt.expected))
+		if err != nil {
+			t.Fatalf("%v to %T: unexpected error %s", tt.v, tt.expected, err)
+		}
+		if !reflect.DeepEqual(v, tt.expected) {
+			t.Fatalf("%v to %T: expected %v, got %v", tt.v, tt.expected, tt.expected, v)
+		}
+	}
+}
+
+func TestConvertValue_shouldFail(t *testing.T) {
+	tests := []struct {
+		v   interface{}
+		typ interface{}
+	}{
+		{"abc", 0},
+		{"300", int8(0)},
+		{"-1", uint(0)},
+		{"yes please", false},
+		{"10 parsecs", time.Duration(0)},
+		{"1,x", []int{}},
+		{1.5, 0},
+		{-1, uint(0)},
+		{1000, int8(0)},
+		{"trace", Level(0)},
+		{true, ""},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		_, err := ConvertValue(tt.v, reflect.TypeOf(tt.typ))
+		if err == nil {
+			t.Fatalf("%v to %T: an error is expected", tt.v, tt.typ)
+		}
+	}
+}
+
+type ServerConfig struct {
+	Port     int           `config:"server.port"`
+	Timeout  time.Duration `config:"server.timeout"`
+	Debug    bool          `config:"server.debug"`
+	Hosts    []string      `config:"server.hosts"`
+	Endpoint *url.URL      `config:"server.endpoint"`
+	Name     string        `config:"server.name"`
+	Prompt   string        `config:"server.prompt"`
+	Secret   []byte        `config:"server.secret"`
+}
+
+func TestGodim_configure_shouldConvertValues(t *testing.T) {
+	values := map[string]interface{}{
+		"server.port":     "8080",
+		"server.timeout":  "2s",
+		"server.debug":    "true",
+		"server.hosts":    "a.local,b.local",
+		"server.endpoint": "http://api.local",
+		"server.name":     nil,
+		"server.prompt":   " > ",
+		"server.secret":   "s3,cr3t",
+	}
+	g := NewConfig().WithConfigurationFunction(mapConfig(values)).Build()
+	sc := &ServerConfig{Name: "kept"}
+	err := g.DeclareDefault(sc)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if sc.Port != 8080 || sc.Timeout != 2*time.Second || !sc.Debug || len(sc.Hosts) != 2 || sc.Endpoint.Host != "api.local" {
+		t.Fatalf("values should be converted, got %+v", sc)
+	}
+	if sc.Name != "kept" {
+		t.Fatalf("a nil value should leave the field unchanged, got %s", sc.Name)
+	}
+	if sc.Prompt != " > " || string(sc.Secret) != "s3,cr3t" {
+		t.Fatalf("strings should be set as is, got %q and %q", sc.Prompt, sc.Secret)
+	}
+}
+
+func TestGodim_configure_shouldNameKeyAndTypeOnFailure(t *testing.T) {
+	g := NewConfig().WithConfigurationFunction(mapConfig(map[string]interface{}{
+		"server.port":     "http",
+		"server.timeout":  "2s",
+		"server.debug":    "true",
+		"server.hosts":    "",
+		"server.endpoint": "http://api.local",
+		"server.name":     "api",
+	})).Build()
+	err := g.DeclareDefault(&ServerConfig{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("An error is expected.")
+	}
+	if !strings.Contains(err.Error(), "config key server.port") || !strings.Contains(err.Error(), "field Port of type int") {
+		t.Fatalf("error should name the key and the field type, got %s", err)
+	}
+}
@@ -0,0 +1,154 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// component tracks the lifecycle methods of a declared value
+type component struct {
+	o       interface{}
+	typ     reflect.Type
+	prio    int
+	onInit  reflect.Value
+	onClose reflect.Value
+	// name is the label:key of a prototype instance, which has no holder
+	name string
+}
+
+// checkCycles walks the dependencies recorded during injection and reject any cycle
+func (registry *Registry) checkCycles() error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	states := make(map[*holder]int)
+	var path []*holder
+	var visit func(h *holder) error
+	visit = func(h *holder) error {
+		switch states[h] {
+		case visited:
+			return nil
+		case visiting:
+			start := 0
+			for path[start] != h {
+				start++
+			}
+			return newError(fmt.Errorf("dependency cycle detected: %s -> %s", holderPath(path[start:]), h)).SetErrType(ErrTypeInjection)
+		}
+		states[h] = visiting
+		path = append(path, h)
+		for _, d := range h.deps {
+			if err := visit(d); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		states[h] = visited
+		return nil
+	}
+	for _, h := range registry.holderList() {
+		if err := visit(h); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// componentName returns the label:key of the holder of a component, or its type when it has none
+func (registry *Registry) componentName(c *component) string {
+	for _, h := range registry.holderList() {
+		if sameInstance(h.o, c.o) {
+			return h.String()
+		}
+	}
+	return c.typ.String()
+}
+
+func holderPath(hs []*holder) string {
+	names := make([]string, len(hs))
+	for i, h := range hs {
+		names[i] = h.String()
+	}
+	return strings.Join(names, " -> ")
+}
+
+// componentDeps returns for each component the nearest components it depends on,
+// going through the holders that have no lifecycle method
+func (registry *Registry) componentDeps() map[*component][]*component {
+	byHolder := make(map[*holder]*component)
+	for _, h := range registry.holderList() {
+		for _, c := range registry.components {
+			if sameInstance(h.o, c.o) {
+				byHolder[h] = c
+			}
+		}
+	}
+	deps := make(map[*component][]*component)
+	for h, c := range byHolder {
+		visited := map[*holder]bool{h: true}
+		var walk func(x *holder)
+		walk = func(x *holder) {
+			for _, d := range x.deps {
+				if visited[d] {
+					continue
+				}
+				visited[d] = true
+				if dc, ok := byHolder[d]; ok {
+					if dc != c {
+						deps[c] = append(deps[c], dc)
+					}
+					continue
+				}
+				walk(d)
+			}
+		}
+		walk(h)
+	}
+	return deps
+}
+
+// initOrder sort components topologically: a component comes after everything it depends on.
+//
+// Priority, then declaration order, break ties between components ready at the same time
+func (registry *Registry) initOrder() []*component {
+	deps := registry.componentDeps()
+	done := make(map[*component]bool)
+	order := make([]*component, 0, len(registry.components))
+	for len(order) < len(registry.components) {
+		var next *component
+		for _, c := range registry.components {
+			if done[c] || !allDone(deps[c], done) {
+				continue
+			}
+			if next == nil || c.prio < next.prio {
+				next = c
+			}
+		}
+		if next == nil {
+			// remaining components are in a cycle, rejected by checkCycles after injection
+			break
+		}
+		done[next] = true
+		order = append(order, next)
+	}
+	return order
+}
+
+func allDone(cs []*component, done map[*component]bool) bool {
+	for _, c := range cs {
+		if !done[c] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameInstance(a, b interface{}) bool {
+	return reflect.ValueOf(a).Kind() == reflect.Ptr && a == b
+}
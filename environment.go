@@ -0,0 +1,47 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// environmentConfig wraps f so that a key prefixed by an active environment is looked up before the key itself.
+//
+// The last environment is the most specific one: with prod and eu active, eu.db.host wins over prod.db.host and db.host.
+// An error or a nil value means the prefixed key is not set
+func environmentConfig(envs []string, f func(key string, val reflect.Value) (interface{}, error)) func(key string, val reflect.Value) (interface{}, error) {
+	if len(envs) == 0 {
+		return f
+	}
+	return func(key string, val reflect.Value) (interface{}, error) {
+		for i := len(envs) - 1; i >= 0; i-- {
+			v, err := f(envs[i]+"."+key, val)
+			if err == nil && v != nil {
+				return v, nil
+			}
+		}
+		return f(key, val)
+	}
+}
+
+// OnEnvironment holds when one of envs is active, see Config.WithActiveEnvironments
+func OnEnvironment(envs ...string) Condition {
+	return Condition{
+		desc: fmt.Sprintf("environment %s is active", strings.Join(envs, " or ")),
+		matches: func(registry *Registry) (bool, error) {
+			for _, active := range registry.environments {
+				for _, env := range envs {
+					if env == active {
+						return true, nil
+					}
+				}
+			}
+			return false, nil
+		},
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"testing"
+)
+
+type DBSettings struct {
+	Host string `config:"db.host"`
+	User string `config:"db.user"`
+}
+
+func TestGodim_WithActiveEnvironments_shouldResolveEnvironmentKeysFirst(t *testing.T) {
+	values := map[string]interface{}{
+		"db.host":      "localhost",
+		"db.user":      "root",
+		"prod.db.host": "db.prod",
+		"prod.db.user": "app",
+		"eu.db.host":   "db.eu",
+	}
+	g := NewConfig().WithActiveEnvironments("prod", " ", "eu").WithConfigurationFunction(mapConfig(values)).Build()
+	dbc := &DBSettings{}
+	err := g.DeclareDefault(dbc)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if dbc.Host != "db.eu" {
+		t.Fatalf("the last environment should win, got %s", dbc.Host)
+	}
+	if dbc.User != "app" {
+		t.Fatalf("prod key should be used when eu has none, got %s", dbc.User)
+	}
+	if envs := g.ActiveEnvironments(); len(envs) != 2 || envs[0] != "prod" || envs[1] != "eu" {
+		t.Fatalf("unexpected active environments %v", envs)
+	}
+}
+
+func TestGodim_OnEnvironment_shouldDeclareForActiveEnvironment(t *testing.T) {
+	for _, env := range []string{"dev", "prod"} {
+		g := NewConfig().WithActiveEnvironments(env).Build()
+		cu := &CacheUser{}
+		err := g.DeclareDefault(cu)
+		if err != nil {
+			t.Fatalf("Error while declaring default: %s.", err)
+		}
+		err = g.DeclareIf(OnEnvironment("dev", "test"), defaultStr, &MemCache{})
+		if err != nil {
+			t.Fatalf("Error while declaring memory cache: %s.", err)
+		}
+		err = g.DeclareIf(OnEnvironment("prod"), defaultStr, &RedisCache{URL: "redis://prod"})
+		if err != nil {
+			t.Fatalf("Error while declaring redis cache: %s.", err)
+		}
+		err = g.RunApp()
+		if err != nil {
+			t.Fatalf("Error while running app: %s.", err)
+		}
+		expected := map[string]string{"dev": "memory", "prod": "redis redis://prod"}[env]
+		if cu.Cache == nil || cu.Cache.Name() != expected {
+			t.Fatalf("%s: expected %s cache, got %v", env, expected, cu.Cache)
+		}
+	}
+}
+
+func TestNewChild_shouldInheritEnvironments(t *testing.T) {
+	parent := NewConfig().WithActiveEnvironments("prod").Build()
+	err := parent.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running parent: %s.", err)
+	}
+	child := NewChild(parent)
+	if envs := child.ActiveEnvironments(); len(envs) != 1 || envs[0] != "prod" {
+		t.Fatalf("child should inherit the environments, got %v", envs)
+	}
+}
@@ -4,6 +4,11 @@
 
 package godim
 
+import (
+	"fmt"
+	"strings"
+)
+
 // ErrType base type for Error Type
 type ErrType uint64
 
@@ -33,6 +38,11 @@ func (err Error) Error() string {
 	return err.Err.Error()
 }
 
+// Unwrap returns the underlying error, for errors.Is and errors.As.
+func (err Error) Unwrap() error {
+	return err.Err
+}
+
 // SetErrType sets the error's type.
 func (err *Error) SetErrType(er ErrType) *Error {
 	err.Type = er
@@ -49,3 +59,48 @@ var _ error = &Error{}
 func newError(err error) *Error {
 	return &Error{Err: err}
 }
+
+// KeyError is an error raised by the service declared under Key
+type KeyError struct {
+	Key string
+	Err error
+}
+
+// Error from error interface.
+func (ke KeyError) Error() string {
+	return fmt.Sprintf("%s: %s", ke.Key, ke.Err)
+}
+
+// Unwrap returns the error raised by the service.
+func (ke KeyError) Unwrap() error {
+	return ke.Err
+}
+
+// MultiError gathers the errors raised by several services, in the order they occurred
+type MultiError struct {
+	Errors []KeyError
+}
+
+func (me *MultiError) add(key string, err error) {
+	me.Errors = append(me.Errors, KeyError{Key: key, Err: err})
+}
+
+// Error from error interface.
+func (me *MultiError) Error() string {
+	msgs := make([]string, len(me.Errors))
+	for i, ke := range me.Errors {
+		msgs[i] = ke.Error()
+	}
+	return fmt.Sprintf("%d error(s) occurred: %s", len(me.Errors), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns every gathered error, for errors.Is and errors.As.
+func (me *MultiError) Unwrap() []error {
+	errs := make([]error, len(me.Errors))
+	for i, ke := range me.Errors {
+		errs[i] = ke
+	}
+	return errs
+}
+
+var _ error = &MultiError{}
@@ -345,6 +345,35 @@ func (es *EventSwitch) AddInterceptor(e EventInterceptor) error {
 	return nil
 }
 
+// remove forgets every registration of o, used when a declared service is overridden
+func (es *EventSwitch) remove(o interface{}) {
+	if es.running {
+		return
+	}
+	for i, e := range es.emitters {
+		if sameInstance(e, o) {
+			es.emitters = append(es.emitters[:i], es.emitters[i+1:]...)
+			break
+		}
+	}
+	for et, rs := range es.receivers {
+		for i, r := range rs {
+			if sameInstance(r, o) {
+				es.receivers[et] = append(rs[:i], rs[i+1:]...)
+				break
+			}
+		}
+	}
+	for prio, interceptor := range es.interceptors {
+		if sameInstance(interceptor, o) {
+			delete(es.interceptors, prio)
+		}
+	}
+	if sameInstance(es.eventFinalizer, o) {
+		es.eventFinalizer = nil
+	}
+}
+
 // Start initialize the EventSwitch and start it
 func (es *EventSwitch) Start() {
 	if es.running {
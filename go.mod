@@ -1 +1,3 @@
-module github.com/ekino/godim
\ No newline at end of file
+module github.com/ekino/godim
+
+go 1.20
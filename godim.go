@@ -15,6 +15,7 @@ type Godim struct {
 	registry       *Registry
 	configFunction func(key string, val reflect.Value) (interface{}, error)
 	eventSwitch    *EventSwitch
+	parent         *Godim
 }
 
 // Default build a default Godim from default configuration
@@ -34,6 +35,25 @@ func NewGodim(config *Config) *Godim {
 	return &g
 }
 
+// NewChild build a Godim whose services can be injected with the ones of parent.
+//
+// Without config, the child gets the parent tags, profile, environments and configuration function,
+// otherwise the child is built from the given config: a profile given with WithAppProfile must then declare the parent labels
+// injected in the child, the injections of parent services being validated against it.
+// The child has its own declarations, lifecycle and closers: closing it leaves the parent untouched.
+func NewChild(parent *Godim, config ...*Config) *Godim {
+	if len(config) > 0 && config[0] != nil {
+		return config[0].BuildChild(parent)
+	}
+	return NewConfig().
+		WithInjectString(parent.registry.inject).
+		WithConfigString(parent.registry.config).
+		WithAppProfile(parent.registry.appProfile).
+		WithConfigurationFunction(parent.configFunction).
+		WithActiveEnvironments(parent.registry.environments...).
+		BuildChild(parent)
+}
+
 // DeclareDefault : declare all your defaults services
 func (godim *Godim) DeclareDefault(o ...interface{}) error {
 	if godim.lifecycle.current(stDeclaration) {
@@ -51,6 +71,10 @@ func (godim *Godim) DeclareDefault(o ...interface{}) error {
 }
 
 // Declare specific level
+//
+// Each element is either a pointer to a struct or a provider function
+// like func(cfg *DBConfig, log Logger) (*UserRepository, error).
+// Providers are called during injection phase, their parameters being resolved by type from the registry.
 func (godim *Godim) Declare(label string, o ...interface{}) error {
 	if godim.lifecycle.current(stDeclaration) {
 		for _, v := range o {
@@ -65,14 +89,122 @@ func (godim *Godim) Declare(label string, o ...interface{}) error {
 	return nil
 }
 
+// DeclareValue declare any value under an explicit key, like a *http.Client, a time.Location or an interface implementation.
+//
+// The value can then be injected in tagged fields by key or by type.
+func (godim *Godim) DeclareValue(label, key string, value interface{}) error {
+	if !godim.lifecycle.current(stDeclaration) {
+		return newError(fmt.Errorf("current phase %s", godim.lifecycle)).SetErrType(ErrTypeGodim)
+	}
+	err := godim.registry.declareValue(label, key, value)
+	if err != nil {
+		return newError(err).SetErrType(ErrTypeGodim)
+	}
+	return nil
+}
+
+// Install applies modules and their nested modules, see Module.
+//
+// Modules are checked before any declaration: a missing profile or a key declared by two modules fails the whole install.
+// A module already installed is skipped.
+func (godim *Godim) Install(modules ...*Module) error {
+	if !godim.lifecycle.current(stDeclaration) {
+		return newError(fmt.Errorf("current phase %s", godim.lifecycle)).SetErrType(ErrTypeGodim)
+	}
+	err := godim.registry.install(modules)
+	if err != nil {
+		return newError(err).SetErrType(ErrTypeGodim)
+	}
+	return nil
+}
+
+// DeclareIf declare o in label only if cond holds.
+//
+// Conditions are evaluated at the end of the configuration phase, before the injection phase,
+// the decisions are logged and listed by Conditions.
+func (godim *Godim) DeclareIf(cond Condition, label string, o ...interface{}) error {
+	if !godim.lifecycle.current(stDeclaration) {
+		return newError(fmt.Errorf("current phase %s", godim.lifecycle)).SetErrType(ErrTypeGodim)
+	}
+	err := godim.registry.declareIf(cond, label, o)
+	if err != nil {
+		return newError(err).SetErrType(ErrTypeGodim)
+	}
+	return nil
+}
+
+// ActiveEnvironments returns the environments given to Config.WithActiveEnvironments
+func (godim *Godim) ActiveEnvironments() []string {
+	return append([]string(nil), godim.registry.environments...)
+}
+
+// Conditions describes the decisions taken on the conditions given to DeclareIf
+func (godim *Godim) Conditions() []string {
+	return append([]string(nil), godim.registry.decisions...)
+}
+
+// Override replaces the service declared under label and key by replacement, typically a fake in tests.
+//
+// The replacement is a struct pointer or a provider, it must fit every injection site of the original,
+// the sites declared after the override are checked before the injection phase.
+// Overrides are only possible in the declaration phase, they are logged and listed by Overrides.
+func (godim *Godim) Override(label, key string, replacement interface{}) error {
+	if !godim.lifecycle.current(stDeclaration) {
+		return newError(fmt.Errorf("current phase %s", godim.lifecycle)).SetErrType(ErrTypeGodim)
+	}
+	err := godim.registry.override(label, key, replacement)
+	if err != nil {
+		return newError(err).SetErrType(ErrTypeGodim)
+	}
+	return nil
+}
+
+// Overrides describes every override applied with Override
+func (godim *Godim) Overrides() []string {
+	descs := make([]string, len(godim.registry.overrides))
+	for i, o := range godim.registry.overrides {
+		descs[i] = o.String()
+	}
+	return descs
+}
+
+// DeclareScoped declare providers whose instances live in scope.
+//
+// ScopeSingleton is the same as Declare, ScopePrototype builds a fresh instance for each injection point,
+// any other name is a custom scope whose instances are built on demand by a Scope opened with NewScope.
+func (godim *Godim) DeclareScoped(scope, label string, o ...interface{}) error {
+	if !godim.lifecycle.current(stDeclaration) {
+		return newError(fmt.Errorf("current phase %s", godim.lifecycle)).SetErrType(ErrTypeGodim)
+	}
+	for _, v := range o {
+		err := godim.registry.declareScoped(scope, label, v)
+		if err != nil {
+			return newError(err).SetErrType(ErrTypeGodim)
+		}
+	}
+	return nil
+}
+
+// NewScope open a custom scope, like a http request or a job run, once the app is running.
+//
+// Close the returned Scope to close the instances it built.
+func (godim *Godim) NewScope(name string) (*Scope, error) {
+	if !godim.lifecycle.current(stRun) {
+		return nil, newError(fmt.Errorf("current phase %s", godim.lifecycle)).SetErrType(ErrTypeGodim)
+	}
+	if name == ScopeSingleton || name == ScopePrototype {
+		return nil, newError(fmt.Errorf("%s is not a custom scope", name)).SetErrType(ErrTypeGodim)
+	}
+	return newScope(name, godim.registry), nil
+}
+
 func (godim *Godim) configure() error {
 	if godim.lifecycle.current(stDeclaration) {
 		godim.lifecycle.currentState++
-		if godim.configFunction != nil {
-			err := godim.registry.configure(godim.configFunction)
-			if err != nil {
-				return err
-			}
+		// run without configuration function too, for the defaults and required keys of config tags
+		err := godim.registry.configure(godim.configFunction)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
@@ -81,7 +213,11 @@ func (godim *Godim) configure() error {
 func (godim *Godim) injection() error {
 	if godim.lifecycle.current(stConfiguration) {
 		godim.lifecycle.currentState++
-		err := godim.registry.injection()
+		err := godim.registry.checkOverrides()
+		if err != nil {
+			return err
+		}
+		err = godim.registry.injection()
 		if err != nil {
 			return err
 		}
@@ -107,6 +243,9 @@ func (godim *Godim) RunApp() error {
 	if godim.lifecycle.current(stRun) || godim.lifecycle.current(stClose) {
 		return newError(fmt.Errorf("Godim is already in state %s", godim.lifecycle)).SetErrType(ErrTypeGodim)
 	}
+	if godim.parent != nil && !godim.parent.lifecycle.current(stRun) {
+		return newError(fmt.Errorf("parent Godim is in state %s", godim.parent.lifecycle)).SetErrType(ErrTypeGodim)
+	}
 	// Configuration phase
 	err := godim.configure()
 	if err != nil {
@@ -133,16 +272,16 @@ func (godim *Godim) RunApp() error {
 }
 
 // CloseApp close all things declared in your app
+//
+// Every OnClose is called, failures are returned together in a MultiError
 func (godim *Godim) CloseApp() error {
-	if err := godim.closeIfRunning(); err != nil {
-		return err
-	}
+	err := godim.closeIfRunning()
 
 	if godim.eventSwitch != nil {
 		godim.eventSwitch.Close()
 	}
 
-	return nil
+	return err
 }
 
 // CloseApp close all things declared in your app
@@ -157,15 +296,26 @@ func (godim *Godim) CloseAppGracefully() error {
 func (godim *Godim) closeIfRunning() error {
 	if godim.lifecycle.current(stRun) {
 		err := godim.registry.closeAll()
-		if err != nil {
-			return err
-		}
 		godim.lifecycle.currentState++
+		return err
 	}
 	return nil
 }
 
+// Injections lists how every inject tag has been resolved, once the injection phase is over.
+//
+// Instances built in a scope are left out
+func (godim *Godim) Injections() []Injection {
+	godim.registry.injectionsMu.Lock()
+	defer godim.registry.injectionsMu.Unlock()
+	injections := make([]Injection, len(godim.registry.injections))
+	copy(injections, godim.registry.injections)
+	return injections
+}
+
 // GetStruct return the stored struct in case it is needed for other usage
+//
+// See Get and GetNamed for typed lookups.
 func (godim *Godim) GetStruct(label, key string) interface{} {
 	return godim.registry.getElement(label, key)
 }
@@ -5,8 +5,11 @@
 package godim
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -167,8 +170,9 @@ func TestGodim_Declare_shouldHandleDependenciesAndGetThemBack(t *testing.T) {
 		t.Fatal("b OnInit not called")
 	}
 
-	if a.PreInitialized == Yes {
-		t.Fatal("a priority not respected in regard to b")
+	// a depends on b so it is initialized after it despite a lower priority
+	if a.PreInitialized != Yes {
+		t.Fatal("a initialized before its dependency b")
 	}
 
 	if c.PreInitialized != Yes {
@@ -250,3 +254,745 @@ func conf(key string, val reflect.Value) (interface{}, error) {
 	}
 	return nil, fmt.Errorf("unknow key %s", key)
 }
+
+type DBConfig struct {
+	URL string `config:"lab.key"`
+}
+
+type UserRepository struct {
+	url string
+}
+
+func (ur *UserRepository) OnInit() error {
+	ur.url = ur.url + "/init"
+	return nil
+}
+
+func NewUserRepository(cfg *DBConfig) (*UserRepository, error) {
+	return &UserRepository{url: cfg.URL}, nil
+}
+
+type UserHandler struct {
+	Repository *UserRepository `inject:"repository:UserRepository"`
+}
+
+func TestGodim_Declare_shouldBuildProviders(t *testing.T) {
+	ap := newAppProfile()
+	ap.AddProfileDef("handler")
+	ap.AddProfileDef("repository", "handler")
+	ap.AddProfileDef("driver", "repository")
+	g := NewConfig().WithAppProfile(ap).WithConfigurationFunction(conf).Build()
+
+	cfg := &DBConfig{}
+	uh := &UserHandler{}
+	err := g.Declare("driver", cfg)
+	if err != nil {
+		t.Fatalf("Error while declaring 'driver': %s.", err)
+	}
+	err = g.Declare("repository", NewUserRepository)
+	if err != nil {
+		t.Fatalf("Error while declaring provider: %s.", err)
+	}
+	err = g.Declare("handler", uh)
+	if err != nil {
+		t.Fatalf("Error while declaring 'handler': %s.", err)
+	}
+
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if uh.Repository == nil {
+		t.Fatal("UserRepository should have been built and injected")
+	}
+	if uh.Repository.url != "bid/init" {
+		t.Fatalf("Wrong built value: 'bid/init' expected but got '%s'.", uh.Repository.url)
+	}
+	if g.GetStruct("repository", "UserRepository") != uh.Repository {
+		t.Fatal("Should have retrieved built UserRepository")
+	}
+}
+
+func TestGodim_Declare_shouldRejectProvidersWithoutDependency(t *testing.T) {
+	g := Default()
+
+	err := g.Declare(defaultStr, func() int { return 1 })
+	if err == nil {
+		t.Fatal("A provider must build a pointer to a struct")
+	}
+	err = g.Declare(defaultStr, NewUserRepository)
+	if err != nil {
+		t.Fatalf("Error while declaring provider: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("An error is expected as DBConfig is not declared")
+	}
+}
+
+type Greeter interface {
+	Greet() string
+}
+
+type EnglishGreeter struct{}
+
+func (eg *EnglishGreeter) Greet() string {
+	return "hello"
+}
+
+type FrenchGreeter struct{}
+
+func (fg *FrenchGreeter) Greet() string {
+	return "bonjour"
+}
+
+type AutoHandler struct {
+	Greeter    Greeter         `inject:""`
+	Repository *UserRepository `inject:"auto"`
+}
+
+func TestGodim_RunApp_shouldAutowireByType(t *testing.T) {
+	g := Default()
+
+	ah := &AutoHandler{}
+	eg := &EnglishGreeter{}
+	ur := &UserRepository{}
+	err := g.DeclareDefault(ah, eg, ur)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if ah.Greeter != eg {
+		t.Fatalf("Greeter not autowired, got %+v.", ah.Greeter)
+	}
+	if ah.Repository != ur {
+		t.Fatalf("Repository not autowired, got %+v.", ah.Repository)
+	}
+}
+
+func TestGodim_RunApp_shouldFailOnAmbiguousAutowiring(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(&AutoHandler{}, &EnglishGreeter{}, &FrenchGreeter{}, &UserRepository{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("An ambiguity error is expected")
+	}
+	if !err.(*Error).IsErrType(ErrTypeInjection) {
+		t.Fatalf("Wrong error type: %s.", err)
+	}
+	if !strings.Contains(err.Error(), "default:EnglishGreeter, default:FrenchGreeter") {
+		t.Fatalf("Error should name the candidates: %s.", err)
+	}
+}
+
+type TypoHandler struct {
+	Repository *UserRepository `inject:"default:UserRepositry"`
+}
+
+type OptionalHandler struct {
+	Repository *UserRepository `inject:"default:UserRepositry,optional"`
+	Greeter    Greeter         `inject:",optional"`
+}
+
+func TestGodim_RunApp_shouldFailOnUnresolvedInjection(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(&TypoHandler{}, &UserRepository{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("An unresolved injection error is expected")
+	}
+	if !err.(*Error).IsErrType(ErrTypeInjection) {
+		t.Fatalf("Wrong error type: %s.", err)
+	}
+	for _, s := range []string{"TypoHandler", "Repository", "UserRepositry"} {
+		if !strings.Contains(err.Error(), s) {
+			t.Fatalf("Error should contain %s: %s.", s, err)
+		}
+	}
+}
+
+func TestGodim_RunApp_shouldSkipOptionalInjection(t *testing.T) {
+	g := Default()
+
+	oh := &OptionalHandler{}
+	err := g.DeclareDefault(oh, &UserRepository{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if oh.Repository != nil || oh.Greeter != nil {
+		t.Fatal("Optional fields should stay nil")
+	}
+}
+
+type CycleA struct {
+	B *CycleB `inject:""`
+}
+
+type CycleB struct {
+	C *CycleC `inject:""`
+}
+
+type CycleC struct {
+	A *CycleA `inject:""`
+}
+
+func TestGodim_RunApp_shouldRejectDependencyCycles(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(&CycleA{}, &CycleB{}, &CycleC{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("A cycle error is expected")
+	}
+	if !strings.Contains(err.Error(), "default:CycleA -> default:CycleB -> default:CycleC -> default:CycleA") {
+		t.Fatalf("Error should show the whole cycle: %s.", err)
+	}
+}
+
+type PA struct{}
+
+type PB struct{}
+
+type PC struct{}
+
+func TestGodim_RunApp_shouldShowProviderCycles(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(
+		func(b *PB) *PA { return &PA{} },
+		func(c *PC) *PB { return &PB{} },
+		func(a *PA) *PC { return &PC{} },
+	)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("A cycle error is expected")
+	}
+	if !strings.Contains(err.Error(), "provider cycle detected: default:PA -> default:PB -> default:PC -> default:PA") {
+		t.Fatalf("Error should show the whole cycle: %s.", err)
+	}
+}
+
+var errFlush = errors.New("flush failed")
+
+type FailingCloser struct {
+	name   string
+	closed bool
+}
+
+func (fc *FailingCloser) Key() string {
+	return fc.name
+}
+
+func (fc *FailingCloser) OnClose() error {
+	fc.closed = true
+	return fmt.Errorf("%s: %w", fc.name, errFlush)
+}
+
+type WorkingCloser struct {
+	closed bool
+}
+
+func (wc *WorkingCloser) OnClose() error {
+	wc.closed = true
+	return nil
+}
+
+func TestGodim_CloseApp_shouldCallEveryCloserAndAggregateErrors(t *testing.T) {
+	g := Default()
+
+	f1 := &FailingCloser{name: "first"}
+	f2 := &FailingCloser{name: "second"}
+	wc := &WorkingCloser{}
+	err := g.DeclareDefault(f1, wc, f2)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+
+	err = g.CloseApp()
+	if err == nil {
+		t.Fatal("Closing errors are expected")
+	}
+	if !f1.closed || !f2.closed || !wc.closed {
+		t.Fatal("Every closer should have been called")
+	}
+	if !err.(*Error).IsErrType(ErrTypeGodim) {
+		t.Fatalf("Wrong error type: %s.", err)
+	}
+	if !errors.Is(err, errFlush) {
+		t.Fatalf("errors.Is should find the closer error in %s.", err)
+	}
+	var me *MultiError
+	if !errors.As(err, &me) {
+		t.Fatalf("errors.As should find a MultiError in %s.", err)
+	}
+	if len(me.Errors) != 2 || me.Errors[0].Key != "default:second" || me.Errors[1].Key != "default:first" {
+		t.Fatalf("Wrong aggregated errors: %+v.", me.Errors)
+	}
+	if !g.lifecycle.current(stClose) {
+		t.Fatalf("Wrong state: %s.", g.lifecycle)
+	}
+}
+
+var errInit = errors.New("init failed")
+
+type BrokenService struct {
+	Closer  *WorkingCloser `inject:""`
+	Failing *FailingCloser `inject:""`
+	closed  bool
+}
+
+func (bs *BrokenService) OnInit() error {
+	return errInit
+}
+
+func (bs *BrokenService) OnClose() error {
+	bs.closed = true
+	return nil
+}
+
+func TestGodim_RunApp_shouldRollbackInitializedServicesOnInitFailure(t *testing.T) {
+	g := Default()
+
+	bs := &BrokenService{}
+	wc := &WorkingCloser{}
+	fc := &FailingCloser{name: "failing"}
+	err := g.DeclareDefault(bs, wc, fc)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("An initialization error is expected")
+	}
+	if !wc.closed || !fc.closed {
+		t.Fatal("Already initialized services should have been closed")
+	}
+	if bs.closed {
+		t.Fatal("Failing service should not be closed")
+	}
+	if !errors.Is(err, errInit) || !errors.Is(err, errFlush) {
+		t.Fatalf("Error should carry both the init and the rollback failures: %s.", err)
+	}
+	var me *MultiError
+	if !errors.As(err, &me) || me.Errors[0].Key != "default:BrokenService" {
+		t.Fatalf("Init failure should come first: %s.", err)
+	}
+	if g.CloseApp() != nil {
+		t.Fatal("Closing a non running app should do nothing")
+	}
+}
+
+type SQLRepository struct {
+	name   string
+	inits  int
+	closed bool
+}
+
+func (sr *SQLRepository) Key() string {
+	return sr.name
+}
+
+func (sr *SQLRepository) OnInit() error {
+	sr.inits++
+	return nil
+}
+
+func (sr *SQLRepository) OnClose() error {
+	sr.closed = true
+	return nil
+}
+
+type ReplicatedService struct {
+	Primary *SQLRepository `inject:"repository:primary"`
+	Replica *SQLRepository `inject:"repository:replica"`
+}
+
+func TestGodim_Declare_shouldHandleSeveralInstancesOfTheSameType(t *testing.T) {
+	g := NewConfig().WithAppProfile(StrictHTTPAppProfile()).Build()
+
+	primary := &SQLRepository{name: "primary"}
+	replica := &SQLRepository{name: "replica"}
+	rs := &ReplicatedService{}
+	err := g.Declare("repository", primary, replica)
+	if err != nil {
+		t.Fatalf("Error while declaring 'repository': %s.", err)
+	}
+	err = g.Declare("service", rs)
+	if err != nil {
+		t.Fatalf("Error while declaring 'service': %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if rs.Primary != primary || rs.Replica != replica {
+		t.Fatal("Each instance should be injected under its own key")
+	}
+	if primary.inits != 1 || replica.inits != 1 {
+		t.Fatal("Each instance should be initialized once")
+	}
+	err = g.CloseApp()
+	if err != nil {
+		t.Fatalf("Error while closing app: %s.", err)
+	}
+	if !primary.closed || !replica.closed {
+		t.Fatal("Each instance should be closed")
+	}
+}
+
+type AliasedRepository struct {
+	SQLRepository
+	alias string
+}
+
+func (ar *AliasedRepository) Key() string {
+	return ar.alias
+}
+
+func TestGodim_Declare_shouldInitializeOnceAnInstanceDeclaredTwice(t *testing.T) {
+	g := Default()
+
+	ar := &AliasedRepository{alias: "first"}
+	err := g.DeclareDefault(ar)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	ar.alias = "second"
+	err = g.DeclareDefault(ar)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if ar.inits != 1 {
+		t.Fatalf("Instance should be initialized once, got %d.", ar.inits)
+	}
+}
+
+type ValueHandler struct {
+	Client   *http.Client  `inject:"default:client"`
+	Location time.Location `inject:"default:location"`
+	Greeter  Greeter       `inject:""`
+	Clock    func() int    `inject:"default:clock"`
+}
+
+func TestGodim_DeclareValue_shouldInjectArbitraryValues(t *testing.T) {
+	g := Default()
+
+	vh := &ValueHandler{}
+	client := &http.Client{Timeout: time.Second}
+	err := g.DeclareDefault(vh)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	for key, value := range map[string]interface{}{
+		"client":   client,
+		"location": *time.UTC,
+		"greeter":  Greeter(&FrenchGreeter{}),
+		"clock":    func() int { return 42 },
+	} {
+		err = g.DeclareValue(defaultStr, key, value)
+		if err != nil {
+			t.Fatalf("Error while declaring value %s: %s.", key, err)
+		}
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if vh.Client != client {
+		t.Fatal("Client not injected")
+	}
+	if vh.Location.String() != "UTC" {
+		t.Fatalf("Location not injected, got %s.", vh.Location.String())
+	}
+	if vh.Greeter == nil || vh.Greeter.Greet() != "bonjour" {
+		t.Fatal("Greeter not injected")
+	}
+	if vh.Clock == nil || vh.Clock() != 42 {
+		t.Fatal("Clock not injected")
+	}
+	if g.GetStruct(defaultStr, "client") != client {
+		t.Fatal("Should have retrieved the client")
+	}
+}
+
+type TaggedValue struct {
+	Name    string  `config:"value.name"`
+	Greeter Greeter `inject:""`
+}
+
+func TestGodim_DeclareValue_shouldLeaveStructValuesUntouched(t *testing.T) {
+	g := NewConfig().WithConfigurationFunction(mapConfig(map[string]interface{}{"value.name": "set"})).Build()
+
+	ptr := &TaggedValue{}
+	err := g.DeclareValue(defaultStr, "ptr", ptr)
+	if err != nil {
+		t.Fatalf("Error while declaring value: %s.", err)
+	}
+	err = g.DeclareValue(defaultStr, "value", TaggedValue{Name: "kept"})
+	if err != nil {
+		t.Fatalf("Error while declaring value: %s.", err)
+	}
+	err = g.DeclareDefault(&FrenchGreeter{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if ptr.Name != "set" || ptr.Greeter == nil {
+		t.Fatalf("A pointer to a struct should be configured and injected, got %+v.", ptr)
+	}
+	if v := g.GetStruct(defaultStr, "value").(TaggedValue); v.Name != "kept" || v.Greeter != nil {
+		t.Fatalf("A struct value has no tags, got %+v.", v)
+	}
+}
+
+func TestGodim_Declare_shouldRejectNonStructValues(t *testing.T) {
+	g := Default()
+
+	i := 12
+	err := g.DeclareDefault(&i)
+	if err == nil {
+		t.Fatal("A pointer to an int can't be declared without a key")
+	}
+	err = g.DeclareValue(defaultStr, "int", &i)
+	if err != nil {
+		t.Fatalf("Error while declaring value: %s.", err)
+	}
+	err = g.DeclareValue(defaultStr, "int", 13)
+	if err == nil {
+		t.Fatal("An already defined error is expected")
+	}
+}
+
+type AdminHandler struct {
+	Primary *SQLRepository `inject:"service:primary"`
+	Greeter Greeter        `inject:""`
+}
+
+func TestGodim_NewChild_shouldFallBackToParentRegistry(t *testing.T) {
+	parent := NewConfig().WithAppProfile(HTTPAppProfile()).Build()
+	primary := &SQLRepository{name: "primary"}
+	err := parent.Declare("service", primary)
+	if err != nil {
+		t.Fatalf("Error while declaring 'service': %s.", err)
+	}
+
+	child := NewChild(parent)
+	ah := &AdminHandler{}
+	eg := &EnglishGreeter{}
+	err = child.Declare("handler", ah)
+	if err != nil {
+		t.Fatalf("Error while declaring 'handler': %s.", err)
+	}
+	err = child.Declare("service", eg)
+	if err != nil {
+		t.Fatalf("Error while declaring 'service': %s.", err)
+	}
+	err = child.RunApp()
+	if err == nil {
+		t.Fatal("A child can't run before its parent")
+	}
+
+	err = parent.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running parent: %s.", err)
+	}
+	err = child.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running child: %s.", err)
+	}
+	if ah.Primary != primary || ah.Greeter != eg {
+		t.Fatal("Child services should be injected with parent and child services")
+	}
+	if parent.GetStruct("service", "EnglishGreeter") != nil {
+		t.Fatal("Parent should not see child services")
+	}
+
+	err = child.CloseApp()
+	if err != nil {
+		t.Fatalf("Error while closing child: %s.", err)
+	}
+	if primary.closed || !parent.lifecycle.current(stRun) {
+		t.Fatal("Closing the child should leave the parent untouched")
+	}
+	err = parent.CloseApp()
+	if err != nil || !primary.closed {
+		t.Fatal("Parent should close its services")
+	}
+}
+
+type AdminPanel struct {
+	Primary *SQLRepository `inject:"service:primary"`
+	Greeter Greeter        `inject:""`
+}
+
+func TestGodim_NewChild_shouldUseItsOwnProfile(t *testing.T) {
+	parent := NewConfig().WithAppProfile(HTTPAppProfile()).Build()
+	primary := &SQLRepository{name: "primary"}
+	err := parent.Declare("service", primary, &EnglishGreeter{})
+	if err != nil {
+		t.Fatalf("Error while declaring 'service': %s.", err)
+	}
+	err = parent.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running parent: %s.", err)
+	}
+
+	adminProfile := newAppProfile()
+	adminProfile.AddProfileDef("admin")
+	adminProfile.AddProfileDef("service", "admin")
+	child := NewChild(parent, NewConfig().WithAppProfile(adminProfile))
+	ap := &AdminPanel{}
+	err = child.Declare("admin", ap)
+	if err != nil {
+		t.Fatalf("Error while declaring 'admin': %s.", err)
+	}
+	err = child.Declare("handler", &AdminHandler{})
+	if err == nil {
+		t.Fatal("handler is not a profile of the child")
+	}
+	err = child.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running child: %s.", err)
+	}
+	if ap.Primary != primary || ap.Greeter != parent.GetStruct("service", "EnglishGreeter") {
+		t.Fatal("Child services should be injected with parent services")
+	}
+
+	strict := newAppProfile()
+	strict.AddProfileDef("admin")
+	strict.AddProfileDef("service")
+	child = NewChild(parent, NewConfig().WithAppProfile(strict))
+	err = child.Declare("admin", &AdminPanel{})
+	if err == nil {
+		t.Fatal("Parent services should be validated against the child profile")
+	}
+}
+
+type DBOptions struct {
+	Host     string        `config:"db.host,default=localhost"`
+	Port     int           `config:"db.port,default=5432"`
+	Timeout  time.Duration `config:"db.timeout,default=5s"`
+	User     string        `config:"db.user,required"`
+	Password string        `config:"db.password,required"`
+}
+
+func TestGodim_configure_shouldApplyTagDefaults(t *testing.T) {
+	values := map[string]interface{}{
+		"db.host":     "db.local",
+		"db.user":     "admin",
+		"db.password": "secret",
+	}
+	g := NewConfig().WithConfigurationFunction(mapConfig(values)).Build()
+	opts := &DBOptions{}
+	err := g.DeclareDefault(opts)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if opts.Host != "db.local" || opts.Port != 5432 || opts.Timeout != 5*time.Second || opts.User != "admin" {
+		t.Fatalf("defaults should only fill missing keys, got %+v", opts)
+	}
+}
+
+func TestGodim_configure_shouldApplyTagDefaultsWithoutConfigurationFunction(t *testing.T) {
+	g := NewConfig().Build()
+	c := &CacheSettings{}
+	err := g.DeclareDefault(c)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if c.Size != 128 {
+		t.Fatalf("default should be applied, got %d", c.Size)
+	}
+}
+
+type CacheSettings struct {
+	Size int `config:"cache.size,default=128"`
+}
+
+type MailSender struct {
+	From string `config:"mail.from,required"`
+}
+
+func TestGodim_configure_shouldReportEveryMissingRequiredKey(t *testing.T) {
+	g := NewConfig().WithConfigurationFunction(mapConfig(map[string]interface{}{"db.user": nil})).Build()
+	opts := &DBOptions{}
+	err := g.DeclareDefault(opts)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareDefault(func() *MailSender { return &MailSender{} })
+	if err != nil {
+		t.Fatalf("Error while declaring provider: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatalf("missing required keys should fail the configuration phase")
+	}
+	if !err.(*Error).IsErrType(ErrTypeRegistry) {
+		t.Fatalf("Error should be of registry type, got %s.", err)
+	}
+	var me *MultiError
+	if !errors.As(err, &me) || len(me.Errors) != 3 {
+		t.Fatalf("every missing key should be reported, got %s.", err)
+	}
+	for _, key := range []string{"db.user", "db.password", "mail.from"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("%s should be reported as missing, got %s.", key, err)
+		}
+	}
+	if !g.lifecycle.current(stConfiguration) {
+		t.Fatalf("injection phase should not be reached")
+	}
+}
+
+func TestGodim_Declare_shouldRejectUnknownConfigTagOptions(t *testing.T) {
+	type BadOptions struct {
+		Port int `config:"db.port,defaut=5432"`
+	}
+	g := NewConfig().Build()
+	err := g.DeclareDefault(&BadOptions{})
+	if err == nil || !strings.Contains(err.Error(), "unknown option defaut=5432") {
+		t.Fatalf("unknown option should be rejected, got %v", err)
+	}
+}
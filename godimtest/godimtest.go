@@ -0,0 +1,164 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package godimtest helps writing tests of applications wired with godim.
+//
+//	g := godimtest.New(t).
+//	    WithValues(map[string]interface{}{"db.url": "memory"}).
+//	    Declare("service", &UserService{}).
+//	    Declare("repository", &UserRepository{}).
+//	    Override("repository", "UserRepository", &FakeUserRepository{}).
+//	    Run()
+//	godimtest.AssertInjected(t, g, "repository:UserRepository", "service:UserService")
+package godimtest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ekino/godim"
+)
+
+// Builder declares services, with fakes if needed, and runs a Godim for a test
+type Builder struct {
+	t      testing.TB
+	config *godim.Config
+	values map[string]interface{}
+	steps  []func(g *godim.Godim) error
+}
+
+// New returns a Builder based on the default godim configuration
+func New(t testing.TB) *Builder {
+	return &Builder{
+		t:      t,
+		config: godim.DefaultConfig(),
+	}
+}
+
+// WithConfig use a specific godim configuration
+func (b *Builder) WithConfig(config *godim.Config) *Builder {
+	b.config = config
+	return b
+}
+
+// WithValues use values as configuration, see MapConfig.
+//
+// The values are layered over the configuration function of the godim configuration when running,
+// whatever the order of the WithConfig and WithValues calls
+func (b *Builder) WithValues(values map[string]interface{}) *Builder {
+	if b.values == nil {
+		b.values = make(map[string]interface{})
+	}
+	for key, v := range values {
+		b.values[key] = v
+	}
+	return b
+}
+
+// Declare declares services under label, see godim.Declare
+func (b *Builder) Declare(label string, o ...interface{}) *Builder {
+	b.steps = append(b.steps, func(g *godim.Godim) error {
+		return g.Declare(label, o...)
+	})
+	return b
+}
+
+// DeclareValue declares a value under label and key, see godim.DeclareValue
+func (b *Builder) DeclareValue(label, key string, value interface{}) *Builder {
+	b.steps = append(b.steps, func(g *godim.Godim) error {
+		return g.DeclareValue(label, key, value)
+	})
+	return b
+}
+
+// Install applies modules, see godim.Install
+func (b *Builder) Install(modules ...*godim.Module) *Builder {
+	b.steps = append(b.steps, func(g *godim.Godim) error {
+		return g.Install(modules...)
+	})
+	return b
+}
+
+// Override replaces a service declared before by a fake, see godim.Override
+func (b *Builder) Override(label, key string, fake interface{}) *Builder {
+	b.steps = append(b.steps, func(g *godim.Godim) error {
+		return g.Override(label, key, fake)
+	})
+	return b
+}
+
+// Run builds the Godim, applies the declarations and runs the app.
+//
+// The test fails at once on any error. CloseApp is registered as a test cleanup.
+func (b *Builder) Run() *godim.Godim {
+	b.t.Helper()
+	config := b.config
+	if b.values != nil {
+		// layered on a copy, a config shared by several builders keeps its own configuration function
+		c := *b.config
+		config = c.WithConfigurationFunction(layerValues(b.values, b.config.ConfigurationFunction()))
+	}
+	g := config.Build()
+	for _, step := range b.steps {
+		if err := step(g); err != nil {
+			b.t.Fatalf("godimtest: declaration failed: %s", err)
+		}
+	}
+	if err := g.RunApp(); err != nil {
+		b.t.Fatalf("godimtest: run failed: %s", err)
+	}
+	b.t.Cleanup(func() {
+		if err := g.CloseApp(); err != nil {
+			b.t.Errorf("godimtest: close failed: %s", err)
+		}
+	})
+	return g
+}
+
+// MapConfig returns a configuration function reading values from a map, unknown keys are errors
+func MapConfig(values map[string]interface{}) func(key string, val reflect.Value) (interface{}, error) {
+	return func(key string, val reflect.Value) (interface{}, error) {
+		v, ok := values[key]
+		if !ok {
+			return nil, fmt.Errorf("godimtest: unknown configuration key %s", key)
+		}
+		return v, nil
+	}
+}
+
+// layerValues returns a configuration function reading values first, then f when it is not nil
+func layerValues(values map[string]interface{}, f func(key string, val reflect.Value) (interface{}, error)) func(key string, val reflect.Value) (interface{}, error) {
+	mc := MapConfig(values)
+	if f == nil {
+		return mc
+	}
+	return func(key string, val reflect.Value) (interface{}, error) {
+		if _, ok := values[key]; ok {
+			return mc(key, val)
+		}
+		return f(key, val)
+	}
+}
+
+// AssertInjected checks that source was injected in a field of target, both given as label:key
+func AssertInjected(t testing.TB, g *godim.Godim, source, target string) {
+	t.Helper()
+	for _, i := range g.Injections() {
+		if i.Source == source && i.Target == target {
+			return
+		}
+	}
+	t.Errorf("godimtest: %s has not been injected in %s", source, target)
+}
+
+// AssertAllResolved checks that every inject tag, optional ones included, has been resolved
+func AssertAllResolved(t testing.TB, g *godim.Godim) {
+	t.Helper()
+	for _, i := range g.Injections() {
+		if i.Source == "" {
+			t.Errorf("godimtest: field %s of %s is not resolved (tag %q)", i.Field, i.Target, i.Tag)
+		}
+	}
+}
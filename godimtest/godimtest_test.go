@@ -0,0 +1,180 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godimtest
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ekino/godim"
+)
+
+type Repository interface {
+	Name() string
+}
+
+type SQLRepository struct {
+	URL string `config:"db.url"`
+}
+
+func (sr *SQLRepository) Name() string {
+	return "sql"
+}
+
+type FakeRepository struct{}
+
+func (fr *FakeRepository) Name() string {
+	return "fake"
+}
+
+type Service struct {
+	Repository Repository `inject:"repository:SQLRepository"`
+	Cache      Repository `inject:"repository:Cache,optional"`
+	closed     bool
+}
+
+func (s *Service) OnClose() error {
+	s.closed = true
+	return nil
+}
+
+func TestBuilder_Run(t *testing.T) {
+	s := &Service{}
+	sr := &SQLRepository{}
+	t.Run("run", func(t *testing.T) {
+		app := New(t).
+			WithConfig(godim.NewConfig().WithAppProfile(godim.StrictHTTPAppProfile())).
+			WithValues(map[string]interface{}{"db.url": "memory"}).
+			Declare("repository", sr).
+			Declare("service", s).
+			Run()
+		if sr.URL != "memory" {
+			t.Fatalf("configuration not applied, got %s", sr.URL)
+		}
+		AssertInjected(t, app, "repository:SQLRepository", "service:Service")
+
+		mock := &recorder{TB: t}
+		AssertAllResolved(mock, app)
+		if len(mock.errors) != 1 {
+			t.Fatalf("optional unresolved field should be reported, got %v", mock.errors)
+		}
+		mock = &recorder{TB: t}
+		AssertInjected(mock, app, "service:Service", "repository:SQLRepository")
+		if len(mock.errors) != 1 {
+			t.Fatalf("wrong injection should be reported, got %v", mock.errors)
+		}
+	})
+	if !s.closed {
+		t.Fatal("app should be closed on test cleanup")
+	}
+}
+
+func TestBuilder_Override(t *testing.T) {
+	s := &Service{}
+	app := New(t).
+		WithConfig(godim.NewConfig().WithAppProfile(godim.StrictHTTPAppProfile())).
+		WithValues(map[string]interface{}{"db.url": "memory"}).
+		Declare("repository", &SQLRepository{}).
+		Declare("service", s).
+		Override("repository", "SQLRepository", &FakeRepository{}).
+		Run()
+	if s.Repository.Name() != "fake" {
+		t.Fatal("fake should be injected")
+	}
+	if len(app.Overrides()) != 1 {
+		t.Fatalf("override should be recorded, got %v", app.Overrides())
+	}
+}
+
+func TestBuilder_Install(t *testing.T) {
+	s := &Service{}
+	storage := godim.NewModule("storage").
+		Declare("repository", &SQLRepository{}).
+		WithConfigDefault("db.url", "memory")
+	New(t).
+		WithConfig(godim.NewConfig().WithAppProfile(godim.StrictHTTPAppProfile())).
+		Install(storage).
+		Declare("service", s).
+		Override("repository", "SQLRepository", &FakeRepository{}).
+		Run()
+	if s.Repository.Name() != "fake" {
+		t.Fatal("fake should replace the module service")
+	}
+}
+
+type Settings struct {
+	URL  string `config:"db.url"`
+	User string `config:"db.user"`
+}
+
+func TestBuilder_WithValues(t *testing.T) {
+	user := func(key string, val reflect.Value) (interface{}, error) {
+		if key == "db.user" {
+			return "admin", nil
+		}
+		return nil, fmt.Errorf("unknown key %s", key)
+	}
+	s := &Settings{}
+	New(t).
+		WithValues(map[string]interface{}{"db.url": "memory"}).
+		WithConfig(godim.NewConfig().WithConfigurationFunction(user)).
+		Declare("default", s).
+		Run()
+	if s.URL != "memory" || s.User != "admin" {
+		t.Fatalf("values should be layered over the configuration function, got %+v", s)
+	}
+}
+
+type DefaultedSettings struct {
+	URL  string `config:"db.url"`
+	User string `config:"db.user,default=none"`
+}
+
+func TestBuilder_WithValues_shouldLeaveASharedConfigUntouched(t *testing.T) {
+	config := godim.NewConfig()
+	first := &DefaultedSettings{}
+	New(t).
+		WithConfig(config).
+		WithValues(map[string]interface{}{"db.url": "first", "db.user": "leaked"}).
+		Declare("default", first).
+		Run()
+	second := &DefaultedSettings{}
+	New(t).
+		WithConfig(config).
+		WithValues(map[string]interface{}{"db.url": "second"}).
+		Declare("default", second).
+		Run()
+	if second.URL != "second" || second.User != "none" {
+		t.Fatalf("values of another builder should not leak, got %+v", second)
+	}
+	if config.ConfigurationFunction() != nil {
+		t.Fatal("the shared config should keep its configuration function")
+	}
+}
+
+func TestMapConfig(t *testing.T) {
+	f := MapConfig(map[string]interface{}{"a": 1})
+	v, err := f("a", reflect.Value{})
+	if err != nil || v != 1 {
+		t.Fatalf("wrong value %v, %v", v, err)
+	}
+	_, err = f("b", reflect.Value{})
+	if err == nil {
+		t.Fatal("unknown key should be an error")
+	}
+}
+
+// recorder captures the errors reported by an assertion
+type recorder struct {
+	testing.TB
+	errors []string
+}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Helper() {}
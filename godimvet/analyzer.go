@@ -0,0 +1,456 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package godimvet defines an analyzer checking godim inject and config tags.
+//
+// It checks the syntax of the tags, suggests the closest known key on a probable typo,
+// and, when the app profile is built in the package, reports declarations violating it.
+package godimvet
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/ekino/godim/godimvet/internal/tag"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+//go:generate cp ../internal/tag/tag.go internal/tag/tag.go
+
+const (
+	godimPath  = "github.com/ekino/godim"
+	defaultStr = "default"
+)
+
+const doc = `check godim inject and config tags
+
+The inject and config tag names can be changed with the -inject and -config flags.
+Constant names given to Config.WithInjectString and Config.WithConfigString in the package are honoured too.`
+
+// Analyzer checks godim tags
+var Analyzer = &analysis.Analyzer{
+	Name:     "godimvet",
+	Doc:      doc,
+	Run:      run,
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+}
+
+var (
+	injectFlag string
+	configFlag string
+)
+
+func init() {
+	Analyzer.Flags.StringVar(&injectFlag, "inject", "inject", "name of the inject tag")
+	Analyzer.Flags.StringVar(&configFlag, "config", "config", "name of the config tag")
+}
+
+// checker holds what is known of the godim setup of a package
+type checker struct {
+	pass   *analysis.Pass
+	inject string
+	config string
+	// known keys: type names, constant Key() results and DeclareValue keys
+	keys map[string]bool
+	// profiles maps a label to the labels it can be injected in, nil when the profile is not built in the package
+	profiles map[string]map[string]bool
+	// sources lists the app profiles built in the package
+	sources map[string]bool
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	if !usesGodim(pass.Pkg) {
+		// inject and config are common tag names, only packages using godim are checked
+		return nil, nil
+	}
+	ins := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	c := &checker{
+		pass:    pass,
+		inject:  injectFlag,
+		config:  configFlag,
+		keys:    make(map[string]bool),
+		sources: make(map[string]bool),
+	}
+	for _, name := range pass.Pkg.Scope().Names() {
+		if _, ok := pass.Pkg.Scope().Lookup(name).(*types.TypeName); ok {
+			c.keys[name] = true
+		}
+	}
+
+	var declarations, builds []*ast.CallExpr
+	var childConfigs []ast.Expr
+	profiled := make(map[types.Object]bool)
+	ins.Preorder([]ast.Node{(*ast.CallExpr)(nil), (*ast.FuncDecl)(nil)}, func(n ast.Node) {
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			c.collectKeyMethod(n)
+		case *ast.CallExpr:
+			switch c.godimCall(n) {
+			case "Config.WithInjectString":
+				if s, ok := c.constString(n.Args[0]); ok && strings.TrimSpace(s) != "" {
+					c.inject = strings.TrimSpace(s)
+				}
+			case "Config.WithConfigString":
+				if s, ok := c.constString(n.Args[0]); ok && strings.TrimSpace(s) != "" {
+					c.config = strings.TrimSpace(s)
+				}
+			case "Default":
+				c.sources[defaultStr] = true
+			case "Config.Build", "Config.BuildChild":
+				builds = append(builds, n)
+			case "NewChild":
+				// a child without config inherits the profile of the parent
+				if len(n.Args) > 1 && !c.pass.TypesInfo.Types[n.Args[1]].IsNil() {
+					childConfigs = append(childConfigs, n.Args[1])
+				}
+			case "Config.WithAppProfile":
+				if obj := c.configVar(n); obj != nil {
+					profiled[obj] = true
+				}
+			case "StrictHTTPAppProfile":
+				c.sources["strict"] = true
+				c.addProfile("handler")
+				c.addProfile("service", "handler")
+				c.addProfile("repository", "service")
+				c.addProfile("driver", "repository")
+			case "HTTPAppProfile":
+				c.sources["http"] = true
+				c.addProfile("handler")
+				c.addProfile("service", "handler", "service")
+				c.addProfile("repository", "service")
+			case "AppProfile.AddProfileDef":
+				c.sources["custom"] = true
+				c.addProfileDef(n)
+			case "Godim.DeclareValue", "Module.DeclareValue", "Module.DeclarePrivateValue":
+				if s, ok := c.constString(n.Args[1]); ok {
+					c.keys[s] = true
+				}
+			case "Godim.Declare", "Godim.DeclareDefault", "Godim.DeclareScoped", "Godim.DeclareIf",
+				"Module.Declare", "Module.DeclarePrivate", "Module.DeclareIf":
+				declarations = append(declarations, n)
+			}
+		}
+	})
+
+	// a configuration built without WithAppProfile gives a container with the default profile
+	for _, call := range builds {
+		childConfigs = append(childConfigs, call.Fun.(*ast.SelectorExpr).X)
+	}
+	for _, e := range childConfigs {
+		if c.defaultProfile(e, profiled) {
+			c.sources[defaultStr] = true
+		}
+	}
+
+	// several containers with their own profiles, nothing can be said of a declaration
+	if len(c.sources) > 1 {
+		c.profiles = nil
+	}
+
+	ins.Preorder([]ast.Node{(*ast.StructType)(nil)}, func(n ast.Node) {
+		for _, field := range n.(*ast.StructType).Fields.List {
+			if field.Tag != nil {
+				c.checkTag(field.Tag)
+			}
+		}
+	})
+
+	if c.profiles != nil {
+		for _, call := range declarations {
+			c.checkDeclaration(call)
+		}
+	}
+	return nil, nil
+}
+
+// godimCall returns the name of the godim function or method called, as Type.Method for methods
+func (c *checker) godimCall(call *ast.CallExpr) string {
+	fn, ok := typeutil.Callee(c.pass.TypesInfo, call).(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != godimPath {
+		return ""
+	}
+	sig := fn.Type().(*types.Signature)
+	if sig.Recv() == nil {
+		return fn.Name()
+	}
+	recv := sig.Recv().Type()
+	if p, ok := recv.(*types.Pointer); ok {
+		recv = p.Elem()
+	}
+	if named, ok := recv.(*types.Named); ok {
+		return named.Obj().Name() + "." + fn.Name()
+	}
+	return fn.Name()
+}
+
+// configVar returns the variable at the root of the receiver of a Config method call, nil if it is not a variable
+func (c *checker) configVar(call *ast.CallExpr) types.Object {
+	e := ast.Expr(call)
+	for {
+		call, ok := unparen(e).(*ast.CallExpr)
+		if !ok {
+			break
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok {
+			return nil
+		}
+		e = sel.X
+	}
+	if id, ok := unparen(e).(*ast.Ident); ok {
+		if v, ok := c.pass.TypesInfo.Uses[id].(*types.Var); ok {
+			return v
+		}
+	}
+	return nil
+}
+
+// defaultProfile tells if the configuration e has no app profile: it is built by NewConfig or DefaultConfig
+// without WithAppProfile in its call chain, or it is a variable never given to WithAppProfile
+func (c *checker) defaultProfile(e ast.Expr, profiled map[types.Object]bool) bool {
+	for {
+		call, ok := unparen(e).(*ast.CallExpr)
+		if !ok {
+			break
+		}
+		switch c.godimCall(call) {
+		case "Config.WithAppProfile":
+			return false
+		case "NewConfig", "DefaultConfig":
+			return true
+		}
+		sel, ok := call.Fun.(*ast.SelectorExpr)
+		if !ok || c.godimCall(call) == "" {
+			// built elsewhere, its profile is unknown
+			return false
+		}
+		e = sel.X
+	}
+	id, ok := unparen(e).(*ast.Ident)
+	if !ok {
+		return false
+	}
+	obj := c.pass.TypesInfo.Uses[id]
+	return obj != nil && !profiled[obj]
+}
+
+func unparen(e ast.Expr) ast.Expr {
+	for {
+		p, ok := e.(*ast.ParenExpr)
+		if !ok {
+			return e
+		}
+		e = p.X
+	}
+}
+
+func (c *checker) constString(e ast.Expr) (string, bool) {
+	tv, ok := c.pass.TypesInfo.Types[e]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
+
+// collectKeyMethod records the key of a Key method returning a constant
+func (c *checker) collectKeyMethod(fd *ast.FuncDecl) {
+	if fd.Recv == nil || fd.Name.Name != "Key" || fd.Body == nil || len(fd.Body.List) != 1 {
+		return
+	}
+	ret, ok := fd.Body.List[0].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != 1 {
+		return
+	}
+	if s, ok := c.constString(ret.Results[0]); ok {
+		c.keys[s] = true
+	}
+}
+
+func (c *checker) addProfile(name string, injectIn ...string) {
+	if c.profiles == nil {
+		c.profiles = make(map[string]map[string]bool)
+	}
+	in := make(map[string]bool)
+	for _, i := range injectIn {
+		in[i] = true
+	}
+	c.profiles[name] = in
+}
+
+func (c *checker) addProfileDef(call *ast.CallExpr) {
+	if len(call.Args) == 0 || call.Ellipsis.IsValid() {
+		return
+	}
+	var names []string
+	for _, arg := range call.Args {
+		s, ok := c.constString(arg)
+		if !ok {
+			return
+		}
+		names = append(names, s)
+	}
+	c.addProfile(names[0], names[1:]...)
+}
+
+func (c *checker) checkTag(lit *ast.BasicLit) {
+	raw, err := strconv.Unquote(lit.Value)
+	if err != nil {
+		return
+	}
+	st := reflect.StructTag(raw)
+	if itag, ok := st.Lookup(c.inject); ok {
+		it, err := tag.ParseInject(itag)
+		switch {
+		case err != nil:
+			c.pass.Reportf(lit.Pos(), "%s", err)
+		case !it.Auto && c.profiles != nil && strings.Count(it.Target, ":") != 1:
+			// as godim.AppProfile.validateTag
+			c.pass.Reportf(lit.Pos(), "malformed %s tag %q: target must be label:key with a profile", c.inject, itag)
+		case !it.Auto && c.profiles != nil && c.profiles[it.Label] == nil:
+			c.pass.Reportf(lit.Pos(), "%s tag %q: profile %s does not exist", c.inject, itag, it.Label)
+		case !it.Auto && !c.keys[it.Key]:
+			if s := c.closest(it.Key); s != "" {
+				c.pass.Reportf(lit.Pos(), "%s tag %q: unknown key %s, did you mean %s?", c.inject, itag, it.Key, s)
+			}
+		}
+	}
+	if ctag, ok := st.Lookup(c.config); ok {
+		if _, err := tag.ParseConfig(ctag); err != nil {
+			c.pass.Reportf(lit.Pos(), "%s", err)
+		}
+	}
+}
+
+// usesGodim tells if pkg is godim or imports it
+func usesGodim(pkg *types.Package) bool {
+	if pkg.Path() == godimPath {
+		return true
+	}
+	for _, imp := range pkg.Imports() {
+		if imp.Path() == godimPath {
+			return true
+		}
+	}
+	return false
+}
+
+// closest returns the known key the nearest of key, if it is near enough to be a typo
+func (c *checker) closest(key string) string {
+	best, dist := "", 3
+	for k := range c.keys {
+		if d := levenshtein(key, k); d < dist || (d == dist && k < best) {
+			best, dist = k, d
+		}
+	}
+	if dist > 2 || len(key) < 4 {
+		return ""
+	}
+	return best
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+// checkDeclaration reports the declared structs whose inject tags violate the profile
+func (c *checker) checkDeclaration(call *ast.CallExpr) {
+	args := call.Args
+	label := defaultStr
+	switch c.godimCall(call) {
+	case "Godim.DeclareScoped", "Godim.DeclareIf", "Module.DeclareIf":
+		if len(args) < 2 {
+			return
+		}
+		args = args[1:]
+		fallthrough
+	case "Godim.Declare", "Module.Declare", "Module.DeclarePrivate":
+		if len(args) < 1 {
+			return
+		}
+		s, ok := c.constString(args[0])
+		if !ok {
+			return
+		}
+		label, args = s, args[1:]
+	}
+	if call.Ellipsis.IsValid() {
+		return
+	}
+	if c.profiles[label] == nil {
+		pos := call.Pos()
+		if sel, ok := call.Fun.(*ast.SelectorExpr); ok {
+			// the method name, calls on modules are chained
+			pos = sel.Sel.Pos()
+		}
+		c.pass.Reportf(pos, "%s is not a declared profile", label)
+		return
+	}
+	for _, arg := range args {
+		st := declaredStruct(c.pass.TypesInfo.TypeOf(arg))
+		if st == nil {
+			continue
+		}
+		for i := 0; i < st.NumFields(); i++ {
+			itag, ok := reflect.StructTag(st.Tag(i)).Lookup(c.inject)
+			if !ok {
+				continue
+			}
+			it, err := tag.ParseInject(itag)
+			if err != nil || it.Auto || c.profiles[it.Label] == nil {
+				continue
+			}
+			if !c.profiles[it.Label][label] {
+				c.pass.Reportf(arg.Pos(), "field %s: %s can't be injected in %s", st.Field(i).Name(), it.Label, label)
+			}
+		}
+	}
+}
+
+// declaredStruct returns the struct declared by a pointer to a struct or a provider returning one
+func declaredStruct(t types.Type) *types.Struct {
+	if sig, ok := t.(*types.Signature); ok {
+		if sig.Results().Len() == 0 {
+			return nil
+		}
+		t = sig.Results().At(0).Type()
+	}
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	st, _ := t.Underlying().(*types.Struct)
+	return st
+}
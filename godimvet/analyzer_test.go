@@ -0,0 +1,57 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godimvet
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+func TestAnalyzer_withProfile(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "a")
+}
+
+func TestAnalyzer_withCustomInjectString(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "b")
+}
+
+func TestAnalyzer_withoutGodimImport(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "c")
+}
+
+func TestAnalyzer_withDefaultAndCustomProfiles(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "d")
+}
+
+func TestAnalyzer_withProfileGivenToAVariable(t *testing.T) {
+	analysistest.Run(t, analysistest.TestData(), Analyzer, "e")
+}
+
+func TestLevenshtein(t *testing.T) {
+	if d := levenshtein("UserRepositry", "UserRepository"); d != 1 {
+		t.Fatalf("expected distance 1, got %d", d)
+	}
+	if d := levenshtein("", "abc"); d != 3 {
+		t.Fatalf("expected distance 3, got %d", d)
+	}
+}
+
+func TestTag_shouldMatchTheRuntimeParser(t *testing.T) {
+	src, err := os.ReadFile(filepath.Join("..", "internal", "tag", "tag.go"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	cp, err := os.ReadFile(filepath.Join("internal", "tag", "tag.go"))
+	if err != nil {
+		t.Fatalf("Unexpected error %s", err)
+	}
+	if !bytes.Equal(src, cp) {
+		t.Fatal("internal/tag/tag.go is outdated, run: go generate")
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Command godimvet checks godim inject and config tags.
+//
+// It runs standalone or through go vet:
+//
+//	godimvet ./...
+//	go vet -vettool=$(which godimvet) ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"github.com/ekino/godim/godimvet"
+)
+
+func main() {
+	singlechecker.Main(godimvet.Analyzer)
+}
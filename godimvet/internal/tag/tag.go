@@ -0,0 +1,101 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+// Package tag parses the inject and config struct tags.
+//
+// It is shared by the godim runtime, the godim command and godimvet,
+// which keep a copy of this file in their internal/tag package, refreshed by go generate
+package tag
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// Default is the label of an inject target without label
+	Default        = "default"
+	autoInject     = "auto"
+	optionalInject = "optional"
+	requiredConfig = "required"
+	defaultOption  = "default="
+)
+
+// Inject is the parsed form of an inject tag
+//
+// "label:key" targets a declared key, "key" targets the default profile,
+// "" or "auto" targets the only declared service assignable to the field.
+// The target can be followed by options, e.g. "service:Cache,optional"
+type Inject struct {
+	Target   string
+	Label    string
+	Key      string
+	Auto     bool
+	Optional bool
+}
+
+// ParseInject parses an inject tag
+func ParseInject(itag string) (Inject, error) {
+	parts := strings.Split(itag, ",")
+	it := Inject{Target: strings.TrimSpace(parts[0])}
+	for _, opt := range parts[1:] {
+		switch strings.TrimSpace(opt) {
+		case optionalInject:
+			it.Optional = true
+		default:
+			return it, fmt.Errorf("unknown option %s in inject tag %s", opt, itag)
+		}
+	}
+	if it.Target == "" || it.Target == autoInject {
+		it.Auto = true
+		return it, nil
+	}
+	elts := strings.SplitN(it.Target, ":", 2)
+	if len(elts) == 1 {
+		it.Label, it.Key = Default, elts[0]
+	} else {
+		it.Label, it.Key = elts[0], elts[1]
+	}
+	return it, nil
+}
+
+// Config is the parsed form of a config tag
+//
+// The key can be followed by options, "required" fails the configuration phase when the key has no value,
+// "default=value" is used when it has none. default comes last so that its value can hold commas,
+// e.g. "db.hosts,default=a,b"
+type Config struct {
+	Key        string
+	Def        string
+	HasDefault bool
+	Required   bool
+}
+
+// ParseConfig parses a config tag
+func ParseConfig(ctag string) (Config, error) {
+	parts := strings.Split(ctag, ",")
+	ct := Config{Key: strings.TrimSpace(parts[0])}
+	if ct.Key == "" {
+		return ct, fmt.Errorf("no key in config tag %s", ctag)
+	}
+	for i, opt := range parts[1:] {
+		opt = strings.TrimSpace(opt)
+		switch {
+		case opt == requiredConfig:
+			ct.Required = true
+		case strings.HasPrefix(opt, defaultOption):
+			ct.HasDefault = true
+			ct.Def = strings.TrimSpace(strings.Join(append([]string{strings.TrimPrefix(opt, defaultOption)}, parts[i+2:]...), ","))
+		default:
+			return ct, fmt.Errorf("unknown option %s in config tag %s", opt, ctag)
+		}
+		if ct.HasDefault {
+			break
+		}
+	}
+	if ct.Required && ct.HasDefault {
+		return ct, fmt.Errorf("config tag %s can't be required and have a default", ctag)
+	}
+	return ct, nil
+}
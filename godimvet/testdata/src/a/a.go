@@ -0,0 +1,60 @@
+package a
+
+import "github.com/ekino/godim"
+
+type UserRepository struct {
+	Host string `config:"db.host"`
+	Port int    `config:""` // want `no key in config tag`
+}
+
+type DBSettings struct {
+	Port     int      `config:"db.port,default=5432"`
+	Hosts    []string `config:"db.hosts,default=a,b"`
+	Password string   `config:"db.password,required"`
+	User     string   `config:"db.user,mandatory"`              // want `unknown option mandatory in config tag db.user,mandatory`
+	Name     string   `config:"db.name,required,default=godim"` // want `config tag db.name,required,default=godim can't be required and have a default`
+}
+
+type Cache struct{}
+
+func (c *Cache) Key() string { return "cache" }
+
+type UserService struct {
+	Repo  *UserRepository `inject:"repository:UserRepository"`
+	Typo  *UserRepository `inject:"repository:UserRepositry"` // want `inject tag "repository:UserRepositry": unknown key UserRepositry, did you mean UserRepository\?`
+	Cache *Cache          `inject:"repository:cache,optional"`
+	Auto  *Cache          `inject:""`
+	Bad   *Cache          `inject:"repository:cache,lazy"` // want `unknown option lazy in inject tag repository:cache,lazy`
+	Layer *Cache          `inject:"cache:cache"`           // want `inject tag "cache:cache": profile cache does not exist`
+	Short *Cache          `inject:"cache"`                 // want `malformed inject tag "cache": target must be label:key with a profile`
+}
+
+type Mailer struct {
+	Sender string `inject:"service:sender"`
+	Token  string `inject:"service:token"`
+	Typo   string `inject:"service:sendr"` // want `inject tag "service:sendr": unknown key sendr, did you mean sender\?`
+}
+
+type UserHandler struct {
+	Repo *UserRepository `inject:"repository:UserRepository"`
+}
+
+func wire(g *godim.Godim) {
+	godim.NewConfig().WithAppProfile(godim.StrictHTTPAppProfile())
+	g.Declare("repository", &Cache{})
+	g.Declare("repository", &UserRepository{})
+	g.Declare("service", &UserService{})
+	g.Declare("handler", &UserHandler{})                     // want `field Repo: repository can't be injected in handler`
+	g.Declare("controller", &UserHandler{})                  // want `controller is not a declared profile`
+	g.Declare("handler", func() *UserHandler { return nil }) // want `field Repo: repository can't be injected in handler`
+}
+
+func mailModule() *godim.Module {
+	return godim.NewModule("mail").
+		DeclareValue("service", "sender", "noreply@ekino.com").
+		DeclarePrivateValue("service", "token", "secret").
+		Declare("handler", &Mailer{}).
+		DeclarePrivate("handler", &UserHandler{}).             // want `field Repo: repository can't be injected in handler`
+		DeclareIf(godim.Condition{}, "controller", &Mailer{}). // want `controller is not a declared profile`
+		Declare("handler", func() *UserHandler { return nil }) // want `field Repo: repository can't be injected in handler`
+}
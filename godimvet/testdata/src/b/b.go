@@ -0,0 +1,20 @@
+package b
+
+import "github.com/ekino/godim"
+
+type Mailer struct{}
+
+type Notifier struct {
+	Mailer *Mailer `wire:"Mailer"`
+	Other  *Mailer `wire:"Mailr"` // want `wire tag "Mailr": unknown key Mailr, did you mean Mailer\?`
+	Skip   *Mailer `inject:"not,checked"`
+	Clock  string  `wire:"clock"`
+	Pair   string  `wire:"svc:a:b"`
+}
+
+func wire(g *godim.Godim) {
+	godim.NewConfig().WithInjectString("wire")
+	g.DeclareValue("default", "clock", "now")
+	g.DeclareValue("svc", "a:b", "pair")
+	g.Declare("anything", &Notifier{})
+}
@@ -0,0 +1,8 @@
+package c
+
+// Settings belongs to a package which does not use godim, its tags follow another library rules
+type Settings struct {
+	Port  int    `config:"port,omitempty"`
+	Name  string `config:""`
+	Store string `inject:"some thing"`
+}
@@ -0,0 +1,26 @@
+package d
+
+import "github.com/ekino/godim"
+
+type Clock struct{}
+
+type Scheduler struct {
+	Clock *Clock `inject:"default:Clock"`
+}
+
+type Job struct {
+	Clock *Clock `inject:"service:Clock"`
+}
+
+// wire builds a container with a custom profile and another one with the default profile,
+// declarations can't be checked against one of them
+func wire(ap *godim.AppProfile) {
+	ap.AddProfileDef("service")
+	ap.AddProfileDef("repository", "service")
+	cfg := godim.NewConfig()
+	cfg.WithAppProfile(ap)
+	cfg.Build().Declare("service", &Job{})
+
+	g := godim.NewConfig().WithInjectString("inject").Build()
+	g.Declare("default", &Clock{}, &Scheduler{})
+}
@@ -0,0 +1,23 @@
+package e
+
+import "github.com/ekino/godim"
+
+type Clock struct{}
+
+type Job struct {
+	Clock *Clock `inject:"repository:Clock"`
+}
+
+func wire(ap *godim.AppProfile) {
+	ap.AddProfileDef("service")
+	ap.AddProfileDef("repository", "service")
+	cfg := godim.NewConfig()
+	cfg.WithAppProfile(ap)
+	g := cfg.Build()
+	g.Declare("repository", &Clock{})
+	g.Declare("service", &Job{})
+	g.Declare("default", &Clock{}) // want `default is not a declared profile`
+
+	// a child inheriting the profile of its parent
+	godim.NewChild(g).Declare("service", &Job{})
+}
@@ -0,0 +1,45 @@
+// Package godim is a stub of the godim API used by the analyzer tests.
+package godim
+
+type Config struct{}
+
+func NewConfig() *Config                                 { return &Config{} }
+func DefaultConfig() *Config                             { return &Config{} }
+func (c *Config) Build() *Godim                          { return &Godim{} }
+func (c *Config) BuildChild(parent *Godim) *Godim        { return &Godim{} }
+func (c *Config) WithInjectString(inject string) *Config { return c }
+func (c *Config) WithConfigString(config string) *Config { return c }
+func (c *Config) WithAppProfile(ap *AppProfile) *Config  { return c }
+
+type AppProfile struct{}
+
+func StrictHTTPAppProfile() *AppProfile                                    { return &AppProfile{} }
+func HTTPAppProfile() *AppProfile                                          { return &AppProfile{} }
+func (ap *AppProfile) AddProfileDef(name string, injectIn ...string) error { return nil }
+
+type Godim struct{}
+
+func Default() *Godim                                  { return &Godim{} }
+func NewChild(parent *Godim, config ...*Config) *Godim { return &Godim{} }
+
+func (g *Godim) DeclareDefault(o ...interface{}) error                   { return nil }
+func (g *Godim) Declare(label string, o ...interface{}) error            { return nil }
+func (g *Godim) DeclareValue(label, key string, value interface{}) error { return nil }
+func (g *Godim) DeclareScoped(scope, label string, o ...interface{}) error {
+	return nil
+}
+
+type Condition struct{}
+
+type Module struct{}
+
+func NewModule(name string) *Module                                         { return &Module{} }
+func (m *Module) Declare(label string, o ...interface{}) *Module            { return m }
+func (m *Module) DeclareValue(label, key string, value interface{}) *Module { return m }
+func (m *Module) DeclarePrivate(label string, o ...interface{}) *Module     { return m }
+func (m *Module) DeclarePrivateValue(label, key string, value interface{}) *Module {
+	return m
+}
+func (m *Module) DeclareIf(cond Condition, label string, o ...interface{}) *Module {
+	return m
+}
@@ -0,0 +1,216 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Graph is the dependency graph of a Godim, to generate architecture docs from the running code.
+//
+// Edges are known once the injection phase is over.
+type Graph struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+// GraphNode a declared service
+type GraphNode struct {
+	// ID is label:key
+	ID string `json:"id"`
+	// Label is the AppProfile layer of the service
+	Label      string `json:"label"`
+	Key        string `json:"key"`
+	Type       string `json:"type"`
+	Scope      string `json:"scope"`
+	Overridden bool   `json:"overridden,omitempty"`
+}
+
+// GraphEdge a dependency of From on To
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Field is the injected field, empty for a provider parameter
+	Field string `json:"field,omitempty"`
+	Lazy  bool   `json:"lazy,omitempty"`
+	// Enforced is true when a profile rule was checked for this edge
+	Enforced bool `json:"enforced"`
+}
+
+// Graph returns the dependency graph, nodes are sorted by label then key
+func (godim *Godim) Graph() *Graph {
+	return godim.registry.graph()
+}
+
+func (registry *Registry) graph() *Graph {
+	g := &Graph{}
+	overridden := make(map[string]bool)
+	for _, o := range registry.overrides {
+		overridden[o.label+":"+o.key] = true
+	}
+	nodes := make(map[string]bool)
+	addNode := func(h *holder) {
+		if nodes[h.String()] {
+			return
+		}
+		nodes[h.String()] = true
+		g.Nodes = append(g.Nodes, GraphNode{
+			ID:         h.String(),
+			Label:      h.label,
+			Key:        h.key,
+			Type:       h.vtyp.String(),
+			Scope:      h.scope,
+			Overridden: overridden[h.String()],
+		})
+	}
+	// the parameters of lazily built providers are set under the lazy resolution lock
+	registry.lazyMu.Lock()
+	for _, h := range registry.holderList() {
+		addNode(h)
+		for _, p := range h.params {
+			addNode(p)
+			// provider parameters are checked by resolveParam, against the profile of the registry building h
+			g.Edges = append(g.Edges, GraphEdge{From: h.String(), To: p.String(), Enforced: !h.registry.appProfile.isDefault()})
+		}
+	}
+	registry.lazyMu.Unlock()
+	registry.injectionsMu.Lock()
+	injections := append([]Injection(nil), registry.injections...)
+	registry.injectionsMu.Unlock()
+	for _, i := range injections {
+		if i.Source == "" {
+			continue
+		}
+		g.Edges = append(g.Edges, GraphEdge{From: i.Target, To: i.Source, Field: i.Field, Lazy: i.Lazy, Enforced: i.enforced})
+	}
+	// services of a parent registry
+	for _, e := range g.Edges {
+		if !nodes[e.To] {
+			elts := strings.SplitN(e.To, ":", 2)
+			if h := registry.getHolder(elts[0], elts[1]); h != nil {
+				addNode(h)
+			}
+		}
+	}
+	sort.Slice(g.Nodes, func(i, j int) bool {
+		if g.Nodes[i].Label != g.Nodes[j].Label {
+			return g.Nodes[i].Label < g.Nodes[j].Label
+		}
+		return g.Nodes[i].Key < g.Nodes[j].Key
+	})
+	g.Edges = uniqueEdges(g.Edges)
+	return g
+}
+
+// uniqueEdges sorts edges and drops the duplicates produced by prototype instances
+func uniqueEdges(edges []GraphEdge) []GraphEdge {
+	sort.Slice(edges, func(i, j int) bool {
+		a, b := edges[i], edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		if a.To != b.To {
+			return a.To < b.To
+		}
+		return a.Field < b.Field
+	})
+	unique := edges[:0]
+	for i, e := range edges {
+		if i == 0 || e != edges[i-1] {
+			unique = append(unique, e)
+		}
+	}
+	return unique
+}
+
+// groups returns the node indexes by label, labels being sorted
+func (g *Graph) groups() ([]string, map[string][]int) {
+	var labels []string
+	byLabel := make(map[string][]int)
+	for i, n := range g.Nodes {
+		if _, ok := byLabel[n.Label]; !ok {
+			labels = append(labels, n.Label)
+		}
+		byLabel[n.Label] = append(byLabel[n.Label], i)
+	}
+	return labels, byLabel
+}
+
+// DOT renders the graph in Graphviz DOT, with a cluster per label.
+//
+// Lazy edges are dashed, edges checked by a profile rule are bold
+func (g *Graph) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph godim {\n\trankdir=LR;\n")
+	labels, byLabel := g.groups()
+	for _, label := range labels {
+		fmt.Fprintf(&b, "\tsubgraph %q {\n\t\tlabel=%q;\n", "cluster_"+label, label)
+		for _, i := range byLabel[label] {
+			n := g.Nodes[i]
+			fmt.Fprintf(&b, "\t\t%q [label=%q];\n", n.ID, n.Key+"\n"+n.Type)
+		}
+		b.WriteString("\t}\n")
+	}
+	for _, e := range g.Edges {
+		var attrs []string
+		if e.Field != "" {
+			attrs = append(attrs, fmt.Sprintf("label=%q", e.Field))
+		}
+		if e.Lazy {
+			attrs = append(attrs, "style=dashed")
+		} else if e.Enforced {
+			attrs = append(attrs, "style=bold")
+		}
+		fmt.Fprintf(&b, "\t%q -> %q", e.From, e.To)
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// Mermaid renders the graph as a Mermaid flowchart, with a subgraph per label.
+//
+// Lazy edges are dotted, edges checked by a profile rule are thick
+func (g *Graph) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	ids := make(map[string]string, len(g.Nodes))
+	for i, n := range g.Nodes {
+		ids[n.ID] = fmt.Sprintf("n%d", i)
+	}
+	labels, byLabel := g.groups()
+	for _, label := range labels {
+		fmt.Fprintf(&b, "\tsubgraph %s\n", label)
+		for _, i := range byLabel[label] {
+			n := g.Nodes[i]
+			fmt.Fprintf(&b, "\t\t%s[\"%s\"]\n", ids[n.ID], n.Key)
+		}
+		b.WriteString("\tend\n")
+	}
+	for _, e := range g.Edges {
+		arrow := "-->"
+		if e.Lazy {
+			arrow = "-.->"
+		} else if e.Enforced {
+			arrow = "==>"
+		}
+		if e.Field != "" {
+			arrow += "|" + e.Field + "|"
+		}
+		fmt.Fprintf(&b, "\t%s %s %s\n", ids[e.From], arrow, ids[e.To])
+	}
+	return b.String()
+}
+
+// JSON renders the graph as JSON
+func (g *Graph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
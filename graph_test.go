@@ -0,0 +1,110 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+type GraphHandler struct {
+	Service *GraphService     `inject:"service:GraphService"`
+	Lazy    func() *GraphRepo `inject:"repository:GraphRepo"`
+}
+
+type GraphService struct {
+	Repo *GraphRepo `inject:""`
+}
+
+type GraphRepo struct{}
+
+func newGraphApp(t *testing.T) *Godim {
+	ap := newAppProfile()
+	ap.AddProfileDef("handler")
+	ap.AddProfileDef("service", "handler")
+	ap.AddProfileDef("repository", "service", "handler")
+	g := NewConfig().WithAppProfile(ap).Build()
+	for label, o := range map[string]interface{}{"handler": &GraphHandler{}, "service": &GraphService{}, "repository": &GraphRepo{}} {
+		if err := g.Declare(label, o); err != nil {
+			t.Fatalf("Error while declaring %s: %s.", label, err)
+		}
+	}
+	if err := g.RunApp(); err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	return g
+}
+
+func TestGraph_shouldHoldNodesAndEdges(t *testing.T) {
+	g := newGraphApp(t).Graph()
+
+	if len(g.Nodes) != 3 || g.Nodes[0].ID != "handler:GraphHandler" || g.Nodes[2].Label != "service" {
+		t.Fatalf("Wrong nodes: %+v.", g.Nodes)
+	}
+	expected := []GraphEdge{
+		{From: "handler:GraphHandler", To: "repository:GraphRepo", Field: "Lazy", Lazy: true, Enforced: true},
+		{From: "handler:GraphHandler", To: "service:GraphService", Field: "Service", Enforced: true},
+		{From: "service:GraphService", To: "repository:GraphRepo", Field: "Repo", Enforced: true},
+	}
+	if len(g.Edges) != len(expected) {
+		t.Fatalf("Wrong edges: %+v.", g.Edges)
+	}
+	for i, e := range expected {
+		if g.Edges[i] != e {
+			t.Fatalf("Wrong edge %d: %+v, expected %+v.", i, g.Edges[i], e)
+		}
+	}
+}
+
+func TestGraph_renderers(t *testing.T) {
+	g := newGraphApp(t).Graph()
+
+	dot := g.DOT()
+	for _, s := range []string{`subgraph "cluster_service"`, `"handler:GraphHandler" -> "service:GraphService" [label="Service", style=bold];`, "style=dashed"} {
+		if !strings.Contains(dot, s) {
+			t.Fatalf("DOT should contain %s:\n%s", s, dot)
+		}
+	}
+	mermaid := g.Mermaid()
+	for _, s := range []string{"flowchart LR", "subgraph repository", "n0 ==>|Service| n2", "n0 -.->|Lazy| n1"} {
+		if !strings.Contains(mermaid, s) {
+			t.Fatalf("Mermaid should contain %s:\n%s", s, mermaid)
+		}
+	}
+	b, err := g.JSON()
+	if err != nil {
+		t.Fatalf("Error while rendering JSON: %s.", err)
+	}
+	var back Graph
+	if err = json.Unmarshal(b, &back); err != nil || len(back.Edges) != 3 {
+		t.Fatalf("Wrong JSON: %s.", b)
+	}
+}
+
+type GraphAdmin struct {
+	Service *GraphService `inject:""`
+}
+
+func TestGraph_enforcedShouldFollowTheCheckedProfile(t *testing.T) {
+	parent := newGraphApp(t)
+	child := NewChild(parent, NewConfig())
+	if err := child.DeclareDefault(&GraphAdmin{}); err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	if err := child.RunApp(); err != nil {
+		t.Fatalf("Error while running child: %s.", err)
+	}
+
+	g := child.Graph()
+	if len(g.Edges) != 1 || g.Edges[0].To != "service:GraphService" || g.Edges[0].Enforced {
+		t.Fatalf("The child edge is not checked by a profile rule: %+v.", g.Edges)
+	}
+	for _, e := range parent.Graph().Edges {
+		if !e.Enforced {
+			t.Fatalf("The parent edges are checked by its profile: %+v.", e)
+		}
+	}
+}
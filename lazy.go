@@ -0,0 +1,207 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// Lazy is an injectable field resolved on its first use.
+//
+//	type UserHandler struct {
+//	    Mailer godim.Lazy[*Mailer] `inject:"service:Mailer"`
+//	}
+//
+// A service referenced only through lazy fields is built on first use,
+// and lazy fields are not dependencies: they can break a dependency cycle.
+type Lazy[T any] struct {
+	once    sync.Once
+	resolve func() (interface{}, error)
+	value   T
+	err     error
+}
+
+// Get returns the resolved value, it panics if the resolution fails
+func (l *Lazy[T]) Get() T {
+	v, err := l.Value()
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Value returns the resolved value or the resolution error
+func (l *Lazy[T]) Value() (T, error) {
+	l.once.Do(func() {
+		if l.resolve == nil {
+			l.err = newError(fmt.Errorf("lazy %s has not been injected", l.target())).SetErrType(ErrTypeInjection)
+			return
+		}
+		var o interface{}
+		o, l.err = l.resolve()
+		if l.err == nil {
+			l.value = o.(T)
+		}
+	})
+	return l.value, l.err
+}
+
+func (l *Lazy[T]) bind(resolve func() (interface{}, error)) {
+	l.resolve = resolve
+}
+
+func (l *Lazy[T]) target() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// lazyField is implemented by every Lazy type
+type lazyField interface {
+	bind(resolve func() (interface{}, error))
+	target() reflect.Type
+}
+
+var lazyFieldType = reflect.TypeOf((*lazyField)(nil)).Elem()
+
+// lazyTarget returns the type resolved by a lazy field type: func() T, Lazy[T] or *Lazy[T]. It returns nil for other types
+func lazyTarget(typ reflect.Type) reflect.Type {
+	switch {
+	case typ.Kind() == reflect.Func && typ.NumIn() == 0 && typ.NumOut() == 1:
+		return typ.Out(0)
+	case reflect.PtrTo(typ).Implements(lazyFieldType):
+		return reflect.New(typ).Interface().(lazyField).target()
+	case typ.Kind() == reflect.Ptr && typ.Implements(lazyFieldType):
+		return reflect.New(typ.Elem()).Interface().(lazyField).target()
+	}
+	return nil
+}
+
+// bindLazy sets a lazy field that resolves dep on first use
+func (registry *Registry) bindLazy(h, dep *holder, field reflect.Value) error {
+	target := lazyTarget(field.Type())
+	if !dep.vtyp.AssignableTo(target) {
+		return newError(fmt.Errorf("%s of type %s can't be lazily injected in %s %s", dep, dep.vtyp, h, field.Type())).SetErrType(ErrTypeInjection)
+	}
+	h.lazy = append(h.lazy, dep)
+	resolve := func() (interface{}, error) {
+		return registry.resolveOnDemand(h, dep)
+	}
+	switch {
+	case field.Kind() == reflect.Func:
+		var once sync.Once
+		var v reflect.Value
+		var err error
+		field.Set(reflect.MakeFunc(field.Type(), func([]reflect.Value) []reflect.Value {
+			once.Do(func() {
+				var o interface{}
+				o, err = resolve()
+				v = reflect.ValueOf(o)
+			})
+			if err != nil {
+				panic(err)
+			}
+			return []reflect.Value{v}
+		}))
+	case field.Kind() == reflect.Ptr:
+		l := reflect.New(field.Type().Elem())
+		l.Interface().(lazyField).bind(resolve)
+		field.Set(l)
+	default:
+		field.Addr().Interface().(lazyField).bind(resolve)
+	}
+	return nil
+}
+
+// resolveOnDemand resolves dep for a lazy field of h, or for a typed lookup.
+//
+// When the initialization phase has started, the services built by the resolution are initialized at once,
+// after the lazy resolution lock is released so that their OnInit can resolve other lazy fields
+func (registry *Registry) resolveOnDemand(h, dep *holder) (interface{}, error) {
+	res := newResolution(nil)
+	inst, err := registry.resolveShared(res, h, dep)
+	err = res.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	return inst.o, nil
+}
+
+// lateInit is a component built after initializeAll computed its order
+type lateInit struct {
+	registry *Registry
+	c        *component
+	// prototype components are closed with the registry but left out of its components
+	prototype bool
+}
+
+// scheduleLate returns the components built after initializeAll computed its order, in initialization order.
+//
+// They are initialized by the finish of the resolution which built them, the lazy resolution lock must be held
+func (registry *Registry) scheduleLate() []lateInit {
+	if len(registry.scheduled) == len(registry.components) {
+		// nothing was built since the last call, the order is not computed again
+		return nil
+	}
+	var late []lateInit
+	for _, c := range registry.initOrder() {
+		if registry.scheduled[c] {
+			continue
+		}
+		registry.scheduled[c] = true
+		late = append(late, lateInit{registry: registry, c: c})
+	}
+	return late
+}
+
+// initialize initializes a component returned by scheduleLate, without holding the lazy resolution lock
+func (l lateInit) initialize() error {
+	if l.c.onInit.IsValid() {
+		err := callLifecycle(l.c.onInit)
+		if err != nil {
+			return err
+		}
+	}
+	l.registry.lazyMu.Lock()
+	defer l.registry.lazyMu.Unlock()
+	if l.prototype {
+		l.registry.prototypes = append(l.registry.prototypes, l.c)
+		return nil
+	}
+	l.registry.initialized = append(l.registry.initialized, l.c)
+	return nil
+}
+
+// lazyOnly returns the singleton providers only referenced by lazy fields, their build is deferred to their first use
+func (registry *Registry) lazyOnly() map[*holder]bool {
+	lazy := make(map[*holder]bool)
+	eager := make(map[*holder]bool)
+	for _, h := range registry.holderList() {
+		tc := registry.tags[h.typ]
+		if tc == nil || h.template != nil || !h.tagged() {
+			continue
+		}
+		for fieldname, itag := range tc.injects {
+			field, _ := h.typ.FieldByName(fieldname)
+			it, err := parseInjectTag(itag)
+			if err != nil {
+				continue
+			}
+			dep, isLazy, _ := registry.fieldDep(h, fieldname, field.Type, it)
+			if dep == nil {
+				continue
+			}
+			if isLazy {
+				lazy[dep] = true
+			} else {
+				eager[dep] = true
+			}
+		}
+	}
+	for h := range eager {
+		delete(lazy, h)
+	}
+	return lazy
+}
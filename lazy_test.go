@@ -0,0 +1,207 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"testing"
+	"time"
+)
+
+type LazyA struct {
+	B Lazy[*LazyB] `inject:""`
+}
+
+type LazyB struct {
+	A *LazyA `inject:""`
+}
+
+func TestLazy_shouldBreakDependencyCycles(t *testing.T) {
+	g := Default()
+
+	a := &LazyA{}
+	b := &LazyB{}
+	err := g.DeclareDefault(a, b)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if b.A != a || a.B.Get() != b {
+		t.Fatal("Lazy field should resolve its target")
+	}
+}
+
+type Expensive struct {
+	inited bool
+	closed bool
+}
+
+func (e *Expensive) OnInit() error {
+	e.inited = true
+	return nil
+}
+
+func (e *Expensive) OnClose() error {
+	e.closed = true
+	return nil
+}
+
+type ExpensiveUser struct {
+	Expensive func() *Expensive `inject:"default:Expensive"`
+	Pointer   *Lazy[*Expensive] `inject:""`
+}
+
+func TestLazy_shouldDeferProviderBuild(t *testing.T) {
+	g := Default()
+
+	built := 0
+	eu := &ExpensiveUser{}
+	err := g.DeclareDefault(eu, func() *Expensive {
+		built++
+		return &Expensive{}
+	})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if built != 0 {
+		t.Fatal("Expensive should not be built before first use")
+	}
+	e := eu.Expensive()
+	if built != 1 || !e.inited {
+		t.Fatal("Expensive should be built and initialized on first use")
+	}
+	if eu.Expensive() != e || eu.Pointer.Get() != e || built != 1 {
+		t.Fatal("Expensive singleton should be built once")
+	}
+	err = g.CloseApp()
+	if err != nil {
+		t.Fatalf("Error while closing app: %s.", err)
+	}
+	if !e.closed {
+		t.Fatal("Lazily built service should be closed")
+	}
+}
+
+func TestLazy_notInjectedShouldFail(t *testing.T) {
+	var l Lazy[*Expensive]
+	_, err := l.Value()
+	if err == nil {
+		t.Fatal("An error is expected on a lazy field that has not been injected")
+	}
+}
+
+type PInfra struct {
+	inited bool
+	closed bool
+}
+
+func (p *PInfra) OnInit() error {
+	p.inited = true
+	return nil
+}
+
+func (p *PInfra) OnClose() error {
+	p.closed = true
+	return nil
+}
+
+type PInfraLazyUser struct {
+	Infra Lazy[*PInfra] `inject:""`
+}
+
+type PInfraUser struct {
+	Infra *PInfra `inject:""`
+}
+
+func TestLazy_shouldBuildParentProvidersInParent(t *testing.T) {
+	parent := Default()
+	infra := &PInfra{}
+	err := parent.DeclareDefault(func() *PInfra { return infra }, &PInfraLazyUser{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = parent.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running parent: %s.", err)
+	}
+
+	child := NewChild(parent)
+	user := &PInfraUser{}
+	err = child.DeclareDefault(user)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = child.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running child: %s.", err)
+	}
+	if user.Infra != infra || !infra.inited {
+		t.Fatal("Parent provider should be built and initialized for the child")
+	}
+	err = child.CloseApp()
+	if err != nil {
+		t.Fatalf("Error while closing child: %s.", err)
+	}
+	if infra.closed {
+		t.Fatal("Closing the child should leave the parent services untouched")
+	}
+	err = parent.CloseApp()
+	if err != nil {
+		t.Fatalf("Error while closing parent: %s.", err)
+	}
+	if !infra.closed {
+		t.Fatal("Parent should close the services it declared")
+	}
+}
+
+type NestedLazyA struct {
+	B Lazy[*NestedLazyB] `inject:""`
+}
+
+type NestedLazyB struct {
+	C      Lazy[*NestedLazyC] `inject:""`
+	greets string
+}
+
+func (b *NestedLazyB) OnInit() error {
+	b.greets = b.C.Get().name
+	return nil
+}
+
+type NestedLazyC struct {
+	name string
+}
+
+func TestLazy_onInitShouldResolveOtherLazyFields(t *testing.T) {
+	g := Default()
+	a := &NestedLazyA{}
+	err := g.DeclareDefault(a, func() *NestedLazyB { return &NestedLazyB{} }, func() *NestedLazyC { return &NestedLazyC{name: "c"} })
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+
+	done := make(chan *NestedLazyB, 1)
+	go func() {
+		done <- a.B.Get()
+	}()
+	select {
+	case b := <-done:
+		if b.greets != "c" {
+			t.Fatalf("OnInit should resolve the nested lazy field, got %q.", b.greets)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("A lazy resolution in an OnInit should not block")
+	}
+}
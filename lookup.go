@@ -0,0 +1,71 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Get returns the only declared service assignable to T.
+//
+//	us, err := godim.Get[*UserService](g)
+//
+// It fails with an ErrTypeRegistry error if nothing or several services match, or if the injection phase is not over.
+func Get[T any](godim *Godim) (T, error) {
+	var zero T
+	if err := godim.checkLookup(); err != nil {
+		return zero, err
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	candidates := godim.registry.findByType(nil, typ)
+	switch len(candidates) {
+	case 0:
+		return zero, newError(fmt.Errorf("no declared service assignable to %s", typ)).SetErrType(ErrTypeRegistry)
+	case 1:
+	default:
+		return zero, newError(fmt.Errorf("several services assignable to %s: %s", typ, holderNames(candidates))).SetErrType(ErrTypeRegistry)
+	}
+	return lookup[T](godim, candidates[0])
+}
+
+// GetNamed returns the service declared under label and key, typed as T.
+//
+// It fails with an ErrTypeRegistry error if the service is not declared, is private to a module, is not a T,
+// or if the injection phase is not over.
+func GetNamed[T any](godim *Godim, label, key string) (T, error) {
+	var zero T
+	if err := godim.checkLookup(); err != nil {
+		return zero, err
+	}
+	h := godim.registry.getHolder(label, key)
+	if h == nil {
+		return zero, newError(fmt.Errorf("%s:%s is not declared", label, key)).SetErrType(ErrTypeRegistry)
+	}
+	if !h.visibleFrom(nil) {
+		return zero, newError(fmt.Errorf("%s is private to module %s", h, h.module)).SetErrType(ErrTypeRegistry)
+	}
+	typ := reflect.TypeOf((*T)(nil)).Elem()
+	if !h.vtyp.AssignableTo(typ) {
+		return zero, newError(fmt.Errorf("%s is a %s, not a %s", h, h.vtyp, typ)).SetErrType(ErrTypeRegistry)
+	}
+	return lookup[T](godim, h)
+}
+
+func lookup[T any](godim *Godim, h *holder) (T, error) {
+	var zero T
+	o, err := godim.registry.resolveOnDemand(h, h)
+	if err != nil {
+		return zero, newError(err).SetErrType(ErrTypeRegistry)
+	}
+	return o.(T), nil
+}
+
+func (godim *Godim) checkLookup() error {
+	if godim.lifecycle.currentState < stInitialization {
+		return newError(fmt.Errorf("lookup is not available before the end of injection, current phase %s", godim.lifecycle)).SetErrType(ErrTypeRegistry)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"testing"
+)
+
+func TestGet_shouldResolveByType(t *testing.T) {
+	g := Default()
+
+	eg := &EnglishGreeter{}
+	ur := &UserRepository{}
+	err := g.DeclareDefault(eg, ur)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	_, err = Get[*UserRepository](g)
+	if err == nil || !err.(*Error).IsErrType(ErrTypeRegistry) {
+		t.Fatalf("Lookup should be refused before injection, got %v.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+
+	r, err := Get[*UserRepository](g)
+	if err != nil || r != ur {
+		t.Fatalf("Wrong lookup by type: %v, %v.", r, err)
+	}
+	gr, err := Get[Greeter](g)
+	if err != nil || gr != eg {
+		t.Fatalf("Wrong lookup by interface: %v, %v.", gr, err)
+	}
+	_, err = Get[*FrenchGreeter](g)
+	if err == nil || !err.(*Error).IsErrType(ErrTypeRegistry) {
+		t.Fatalf("An error is expected for an undeclared type, got %v.", err)
+	}
+}
+
+func TestGet_shouldFailOnAmbiguousType(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(&EnglishGreeter{}, &FrenchGreeter{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	_, err = Get[Greeter](g)
+	if err == nil {
+		t.Fatal("An ambiguity error is expected")
+	}
+}
+
+func TestGetNamed_shouldCheckType(t *testing.T) {
+	g := Default()
+
+	ur := &UserRepository{}
+	err := g.DeclareDefault(ur)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	r, err := GetNamed[*UserRepository](g, defaultStr, "UserRepository")
+	if err != nil || r != ur {
+		t.Fatalf("Wrong named lookup: %v, %v.", r, err)
+	}
+	_, err = GetNamed[Greeter](g, defaultStr, "UserRepository")
+	if err == nil || !err.(*Error).IsErrType(ErrTypeRegistry) {
+		t.Fatalf("A type error is expected, got %v.", err)
+	}
+	_, err = GetNamed[*UserRepository](g, defaultStr, "Unknown")
+	if err == nil {
+		t.Fatal("An error is expected for an undeclared key")
+	}
+}
@@ -0,0 +1,313 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Module bundles declarations shared across applications, like a database driver with its repositories.
+//
+// A Module groups declarations, nested modules, config defaults and the profiles it requires.
+// Nothing is checked before Godim.Install
+type Module struct {
+	name     string
+	decls    []*moduleDecl
+	includes []*Module
+	defaults map[string]interface{}
+	profiles []string
+}
+
+// configDefault is a config default set by a module
+type configDefault struct {
+	module string
+	value  interface{}
+}
+
+// moduleDecl is a declaration waiting for the module to be installed
+type moduleDecl struct {
+	label string
+	// key is set for values only
+	key  string
+	o    interface{}
+	cond *Condition
+	// private declarations can only be injected in the services of the module
+	private bool
+}
+
+// NewModule returns an empty module named name
+func NewModule(name string) *Module {
+	return &Module{
+		name:     name,
+		defaults: make(map[string]interface{}),
+	}
+}
+
+// Name of the module
+func (m *Module) Name() string {
+	return m.name
+}
+
+// Declare adds structs or providers declared in label, see Godim.Declare
+func (m *Module) Declare(label string, o ...interface{}) *Module {
+	for _, v := range o {
+		m.decls = append(m.decls, &moduleDecl{label: label, o: v})
+	}
+	return m
+}
+
+// DeclareValue adds a value declared in label under key, see Godim.DeclareValue
+func (m *Module) DeclareValue(label, key string, value interface{}) *Module {
+	m.decls = append(m.decls, &moduleDecl{label: label, key: key, o: value})
+	return m
+}
+
+// DeclarePrivate adds structs or providers declared in label, only injectable in the services of m.
+//
+// Private services are left out of autowiring and typed lookups outside of m,
+// naming them in an inject tag of another service fails the injection phase
+func (m *Module) DeclarePrivate(label string, o ...interface{}) *Module {
+	for _, v := range o {
+		m.decls = append(m.decls, &moduleDecl{label: label, o: v, private: true})
+	}
+	return m
+}
+
+// DeclarePrivateValue adds a value declared in label under key, only injectable in the services of m
+func (m *Module) DeclarePrivateValue(label, key string, value interface{}) *Module {
+	m.decls = append(m.decls, &moduleDecl{label: label, key: key, o: value, private: true})
+	return m
+}
+
+// DeclareIf adds structs or providers declared in label if cond holds, see Godim.DeclareIf
+func (m *Module) DeclareIf(cond Condition, label string, o ...interface{}) *Module {
+	for _, v := range o {
+		m.decls = append(m.decls, &moduleDecl{label: label, o: v, cond: &cond})
+	}
+	return m
+}
+
+// Include adds nested modules, installed before the declarations of m.
+//
+// A module included several times is installed once
+func (m *Module) Include(modules ...*Module) *Module {
+	m.includes = append(m.includes, modules...)
+	return m
+}
+
+// WithConfigDefault sets the value of the config key when the configuration function has none
+func (m *Module) WithConfigDefault(key string, value interface{}) *Module {
+	m.defaults[key] = value
+	return m
+}
+
+// RequireProfiles lists the profiles the app profile must declare for m to be installed
+func (m *Module) RequireProfiles(labels ...string) *Module {
+	m.profiles = append(m.profiles, labels...)
+	return m
+}
+
+// install checks then applies modules and their nested modules.
+//
+// Collisions and missing profiles are checked before the first declaration, so they leave the registry untouched.
+// A module is installed once all its declarations succeed, the modules installed before a failure stay installed
+func (registry *Registry) install(modules []*Module) error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	if registry.modules == nil {
+		registry.modules = make(map[string]*Module)
+		registry.configDefaults = make(map[string]*configDefault)
+	}
+	var ordered []*Module
+	states := make(map[*Module]int)
+	var visit func(m *Module, path string) error
+	visit = func(m *Module, path string) error {
+		if m == nil {
+			return newError(fmt.Errorf("nil module in %s", path)).SetErrType(ErrTypeRegistry)
+		}
+		path += m.name
+		switch states[m] {
+		case visiting:
+			return newError(fmt.Errorf("module cycle detected: %s", path)).SetErrType(ErrTypeRegistry)
+		case visited:
+			return nil
+		}
+		states[m] = visiting
+		for _, i := range m.includes {
+			if err := visit(i, path+" -> "); err != nil {
+				return err
+			}
+		}
+		states[m] = visited
+		if registry.modules[m.name] != m {
+			ordered = append(ordered, m)
+		}
+		return nil
+	}
+	for _, m := range modules {
+		if err := visit(m, ""); err != nil {
+			return err
+		}
+	}
+	if err := registry.checkModules(ordered); err != nil {
+		return err
+	}
+
+	for _, m := range ordered {
+		err := registry.installModule(m)
+		if err != nil {
+			return newError(fmt.Errorf("module %s: %w", m.name, err)).SetErrType(ErrTypeRegistry)
+		}
+	}
+	return nil
+}
+
+// installModule applies the declarations of m, then marks it installed.
+//
+// When a declaration fails, the services and conditionals m declared are removed so that Install can be retried
+func (registry *Registry) installModule(m *Module) error {
+	declared := len(registry.holderList())
+	conditionals := len(registry.conditionals)
+	for _, d := range m.decls {
+		err := registry.installDecl(m, d)
+		if err != nil {
+			for _, h := range registry.holderList()[declared:] {
+				registry.remove(h)
+			}
+			registry.conditionals = registry.conditionals[:conditionals]
+			return err
+		}
+	}
+	registry.modules[m.name] = m
+	for key, v := range m.defaults {
+		registry.configDefaults[key] = &configDefault{module: m.name, value: v}
+	}
+	return nil
+}
+
+// checkModules rejects modules whose names, keys or config defaults collide, or whose profiles are missing
+func (registry *Registry) checkModules(modules []*Module) error {
+	owners := make(map[string]string)
+	defaults := make(map[string]*configDefault)
+	for key, d := range registry.configDefaults {
+		defaults[key] = d
+	}
+	for _, m := range modules {
+		if other := registry.modules[m.name]; other != nil && other != m {
+			return newError(fmt.Errorf("another module named %s is already installed", m.name)).SetErrType(ErrTypeRegistry)
+		}
+		for _, label := range m.profiles {
+			if !registry.appProfile.validate(label) {
+				return newError(fmt.Errorf("module %s requires profile %s", m.name, label)).SetErrType(ErrTypeRegistry)
+			}
+		}
+		for _, d := range m.decls {
+			if !registry.appProfile.validate(d.label) {
+				return newError(fmt.Errorf("module %s declares in %s which is not a declared profile", m.name, d.label)).SetErrType(ErrTypeRegistry)
+			}
+			key, ok := d.declarationKey()
+			if !ok || d.cond != nil {
+				// invalid declarations fail when declared, conditional ones may not be declared
+				continue
+			}
+			id := d.label + ":" + key
+			if other, ok := owners[id]; ok && other != m.name {
+				return newError(fmt.Errorf("%s is declared by modules %s and %s", id, other, m.name)).SetErrType(ErrTypeRegistry)
+			}
+			owners[id] = m.name
+			if h := registry.values[d.label][key]; h != nil {
+				if h.module != "" {
+					return newError(fmt.Errorf("%s is declared by modules %s and %s", id, h.module, m.name)).SetErrType(ErrTypeRegistry)
+				}
+				return newError(fmt.Errorf("%s of module %s is already declared", id, m.name)).SetErrType(ErrTypeRegistry)
+			}
+		}
+		for key, v := range m.defaults {
+			if other := defaults[key]; other != nil && other.module != m.name && !reflect.DeepEqual(other.value, v) {
+				return newError(fmt.Errorf("config default %s is set by modules %s and %s", key, other.module, m.name)).SetErrType(ErrTypeRegistry)
+			}
+			defaults[key] = &configDefault{module: m.name, value: v}
+		}
+	}
+	return nil
+}
+
+func (registry *Registry) installDecl(m *Module, d *moduleDecl) error {
+	if d.cond != nil {
+		err := registry.declareIf(*d.cond, d.label, []interface{}{d.o})
+		if err != nil {
+			return err
+		}
+		registry.conditionals[len(registry.conditionals)-1].module = m.name
+		return nil
+	}
+	declared := len(registry.holders)
+	var err error
+	if d.key != "" {
+		err = registry.declareValue(d.label, d.key, d.o)
+	} else {
+		err = registry.declare(d.label, d.o)
+	}
+	for _, h := range registry.holders[declared:] {
+		h.module = m.name
+		h.private = d.private
+	}
+	return err
+}
+
+// declarationKey returns the key the declaration will be registered under, false if it is invalid
+func (d *moduleDecl) declarationKey() (string, bool) {
+	if d.key != "" {
+		return d.key, true
+	}
+	typ := reflect.TypeOf(d.o)
+	if typ == nil {
+		return "", false
+	}
+	if typ.Kind() == reflect.Func {
+		if checkProvider(typ) != nil {
+			return "", false
+		}
+		t := typ.Out(0).Elem()
+		return getKey(t, reflect.New(t).Interface()), true
+	}
+	if typ.Kind() == reflect.Ptr {
+		if reflect.ValueOf(d.o).IsNil() {
+			return "", false
+		}
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || (typ.Name() == "" && !isIdentifier(typ)) {
+		return "", false
+	}
+	return getKey(typ, d.o), true
+}
+
+// defaultsConfig wraps f so that the config defaults of the modules are used for the keys f has no value for
+func defaultsConfig(defaults map[string]*configDefault, f func(key string, val reflect.Value) (interface{}, error)) func(key string, val reflect.Value) (interface{}, error) {
+	if len(defaults) == 0 {
+		return f
+	}
+	return func(key string, val reflect.Value) (interface{}, error) {
+		if f != nil {
+			v, err := f(key, val)
+			if err == nil && v != nil {
+				return v, nil
+			}
+			if d, ok := defaults[key]; ok {
+				return d.value, nil
+			}
+			return v, err
+		}
+		if d, ok := defaults[key]; ok {
+			return d.value, nil
+		}
+		// no configuration function, the key has no value
+		return nil, nil
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"strings"
+	"testing"
+)
+
+type PGDriver struct {
+	URL  string `config:"pg.url"`
+	Pool int    `config:"pg.pool"`
+}
+
+type PGRepository struct {
+	Driver *PGDriver `inject:"driver:PGDriver"`
+}
+
+type Tracer struct{}
+
+type TracedService struct {
+	Repo   *PGRepository `inject:"repository:PGRepository"`
+	Tracer *Tracer       `inject:""`
+}
+
+func observabilityModule() *Module {
+	return NewModule("observability").Declare("repository", &Tracer{})
+}
+
+func TestGodim_Install_shouldApplyModules(t *testing.T) {
+	obs := observabilityModule()
+	pg := NewModule("postgres").
+		Include(obs).
+		RequireProfiles("driver", "repository").
+		Declare("driver", &PGDriver{}).
+		Declare("repository", &PGRepository{}).
+		WithConfigDefault("pg.pool", 10)
+	g := NewConfig().
+		WithAppProfile(StrictHTTPAppProfile()).
+		WithConfigurationFunction(mapConfig(map[string]interface{}{"pg.url": "postgres://db"})).
+		Build()
+	err := g.Install(pg, obs)
+	if err != nil {
+		t.Fatalf("Error while installing modules: %s.", err)
+	}
+	ts := &TracedService{}
+	err = g.Declare("service", ts)
+	if err != nil {
+		t.Fatalf("Error while declaring service: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if ts.Tracer == nil || ts.Repo == nil || ts.Repo.Driver == nil {
+		t.Fatalf("module services should be injected, got %+v", ts)
+	}
+	if ts.Repo.Driver.URL != "postgres://db" || ts.Repo.Driver.Pool != 10 {
+		t.Fatalf("driver should be configured with the module default, got %+v", ts.Repo.Driver)
+	}
+}
+
+func TestGodim_Install_shouldUseDefaultsWithoutConfigurationFunction(t *testing.T) {
+	g := Default()
+	err := g.Install(NewModule("pg").Declare(defaultStr, &PGDriver{URL: "set"}).WithConfigDefault("pg.pool", 5))
+	if err != nil {
+		t.Fatalf("Error while installing module: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	d := g.GetStruct(defaultStr, "PGDriver").(*PGDriver)
+	if d.Pool != 5 || d.URL != "set" {
+		t.Fatalf("default should be set and other fields untouched, got %+v", d)
+	}
+}
+
+func TestGodim_Install_shouldRejectCollisions(t *testing.T) {
+	tests := []struct {
+		name    string
+		modules []*Module
+		err     string
+	}{
+		{
+			"key",
+			[]*Module{NewModule("a").Declare(defaultStr, &Tracer{}), NewModule("b").Declare(defaultStr, &Tracer{})},
+			"default:Tracer is declared by modules a and b",
+		},
+		{
+			"default",
+			[]*Module{NewModule("a").WithConfigDefault("pg.pool", 1), NewModule("b").WithConfigDefault("pg.pool", 2)},
+			"config default pg.pool is set by modules a and b",
+		},
+		{
+			"name",
+			[]*Module{NewModule("a"), NewModule("a")},
+			"another module named a is already installed",
+		},
+		{
+			"profile",
+			[]*Module{NewModule("a").RequireProfiles("driver")},
+			"module a requires profile driver",
+		},
+	}
+	for _, tt := range tests {
+		g := Default()
+		err := g.Install(tt.modules...)
+		if tt.name == "name" && err == nil {
+			// the name collision is found against the installed module
+			err = g.Install(NewModule("a"))
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+		if tt.name == "key" && g.GetStruct(defaultStr, "Tracer") != nil {
+			t.Fatal("a failing install should not declare anything")
+		}
+	}
+}
+
+func TestGodim_Install_shouldRejectInstalledKeys(t *testing.T) {
+	g := Default()
+	err := g.Install(NewModule("a").Declare(defaultStr, &Tracer{}))
+	if err != nil {
+		t.Fatalf("Error while installing module: %s.", err)
+	}
+	err = g.Install(NewModule("b").Declare(defaultStr, &Tracer{}))
+	if err == nil || !strings.Contains(err.Error(), "default:Tracer is declared by modules a and b") {
+		t.Fatalf("collision with an installed module should be rejected, got %v", err)
+	}
+	err = g.DeclareDefault(&PGDriver{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.Install(NewModule("c").Declare(defaultStr, &PGDriver{}))
+	if err == nil || !strings.Contains(err.Error(), "default:PGDriver of module c is already declared") {
+		t.Fatalf("collision with a declared service should be rejected, got %v", err)
+	}
+}
+
+func TestGodim_Install_shouldRejectModuleCycles(t *testing.T) {
+	a := NewModule("a")
+	b := NewModule("b").Include(a)
+	a.Include(b)
+	err := Default().Install(a)
+	if err == nil || !strings.Contains(err.Error(), "module cycle detected: a -> b -> a") {
+		t.Fatalf("module cycle should be rejected, got %v", err)
+	}
+}
+
+func TestGodim_Install_shouldRollBackAFailedModule(t *testing.T) {
+	g := Default()
+	broken := NewModule("pg").
+		Declare(defaultStr, &PGDriver{}).
+		DeclareIf(OnConfig("pg.url"), defaultStr, &PGRepository{}).
+		Declare(defaultStr, &BrokenStore{}).
+		WithConfigDefault("pg.pool", 5)
+	err := g.Install(broken)
+	if err == nil || !strings.Contains(err.Error(), "module pg") {
+		t.Fatalf("a failing declaration should fail the install, got %v", err)
+	}
+	err = g.Install(broken)
+	if err == nil {
+		t.Fatal("a retried install should fail again instead of skipping the module")
+	}
+	err = g.Install(NewModule("pg").Declare(defaultStr, &PGDriver{}).WithConfigDefault("pg.pool", 5))
+	if err != nil {
+		t.Fatalf("Error while installing the fixed module: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	d := g.GetStruct(defaultStr, "PGDriver").(*PGDriver)
+	if d.Pool != 5 || len(g.Conditions()) != 0 {
+		t.Fatalf("only the fixed module should be installed, got %+v and %v", d, g.Conditions())
+	}
+}
+
+type PGPool struct{}
+
+type PGUserRepository struct {
+	Pool *PGPool `inject:"driver:PGPool"`
+}
+
+type PGAutoRepository struct {
+	Pool *PGPool `inject:""`
+}
+
+type PGLazyRepository struct {
+	Pool func() *PGPool `inject:"driver:PGPool"`
+}
+
+func privateModule() *Module {
+	return NewModule("postgres").
+		DeclarePrivate("driver", &PGPool{}).
+		Declare("repository", &PGUserRepository{})
+}
+
+func TestModule_DeclarePrivate_shouldInjectInModule(t *testing.T) {
+	g := NewConfig().WithAppProfile(StrictHTTPAppProfile()).Build()
+	err := g.Install(privateModule())
+	if err != nil {
+		t.Fatalf("Error while installing module: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	repo, err := GetNamed[*PGUserRepository](g, "repository", "PGUserRepository")
+	if err != nil || repo.Pool == nil {
+		t.Fatalf("private pool should be injected in its module, got %v, %v", repo, err)
+	}
+	_, err = GetNamed[*PGPool](g, "driver", "PGPool")
+	if err == nil || !strings.Contains(err.Error(), "driver:PGPool is private to module postgres") {
+		t.Fatalf("private service lookup should fail, got %v", err)
+	}
+	_, err = Get[*PGPool](g)
+	if err == nil {
+		t.Fatal("private service should not be found by type")
+	}
+	sc, err := g.NewScope(RequestScope)
+	if err != nil {
+		t.Fatalf("Error while creating scope: %s.", err)
+	}
+	_, err = sc.Get("driver", "PGPool")
+	if err == nil || !strings.Contains(err.Error(), "driver:PGPool is private to module postgres") {
+		t.Fatalf("private service scope lookup should fail, got %v", err)
+	}
+}
+
+func TestModule_DeclarePrivate_shouldRejectInjectionFromOutside(t *testing.T) {
+	tests := []struct {
+		name string
+		o    interface{}
+		err  string
+	}{
+		{"tag", &PGUserRepository{}, "driver:PGPool is private to module postgres, it can't be injected in repository:PGUserRepository"},
+		{"lazy", &PGLazyRepository{}, "driver:PGPool is private to module postgres, it can't be injected in repository:PGLazyRepository"},
+		{"auto", &PGAutoRepository{}, "no declared service assignable to *godim.PGPool"},
+	}
+	for _, tt := range tests {
+		g := NewConfig().WithAppProfile(StrictHTTPAppProfile()).Build()
+		err := g.Install(NewModule("postgres").DeclarePrivate("driver", &PGPool{}))
+		if err != nil {
+			t.Fatalf("Error while installing module: %s.", err)
+		}
+		err = g.Declare("repository", tt.o)
+		if err != nil {
+			t.Fatalf("Error while declaring repository: %s.", err)
+		}
+		err = g.RunApp()
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("%s: expected error %q, got %v", tt.name, tt.err, err)
+		}
+		if tt.name != "auto" && !err.(*Error).IsErrType(ErrTypeProfile) {
+			t.Fatalf("%s: visibility errors should be profile errors", tt.name)
+		}
+	}
+}
+
+func TestModule_DeclarePrivate_shouldKeepVisibilityOnOverride(t *testing.T) {
+	g := NewConfig().WithAppProfile(StrictHTTPAppProfile()).Build()
+	err := g.Install(privateModule())
+	if err != nil {
+		t.Fatalf("Error while installing module: %s.", err)
+	}
+	err = g.Override("driver", "PGPool", &PGPool{})
+	if err != nil {
+		t.Fatalf("Error while overriding pool: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	_, err = Get[*PGPool](g)
+	if err == nil {
+		t.Fatal("overridden private service should stay private")
+	}
+}
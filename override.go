@@ -0,0 +1,176 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+)
+
+// override records the replacement of a declared service
+type override struct {
+	label string
+	key   string
+	from  reflect.Type
+	to    reflect.Type
+}
+
+func (o *override) String() string {
+	return fmt.Sprintf("%s:%s overridden, %s replaced by %s", o.label, o.key, o.from, o.to)
+}
+
+// override replaces the service declared under label and key by replacement, a struct pointer or a provider.
+//
+// The replacement must be assignable to every field and provider parameter the original could be injected in.
+// It is fully checked before the original is removed, so a rejected override leaves the registry untouched
+func (registry *Registry) override(label, key string, replacement interface{}) error {
+	orig := registry.values[label][key]
+	if orig == nil {
+		return newError(fmt.Errorf("%s:%s is not declared, nothing to override", label, key)).SetErrType(ErrTypeRegistry)
+	}
+	if replacement == nil {
+		return newError(fmt.Errorf("can't override %s with nil", orig)).SetErrType(ErrTypeRegistry)
+	}
+	vtyp := reflect.TypeOf(replacement)
+	isProvider := vtyp.Kind() == reflect.Func
+	if isProvider {
+		if err := checkProvider(vtyp); err != nil {
+			return err
+		}
+		vtyp = vtyp.Out(0)
+	} else if orig.scope != ScopeSingleton {
+		return newError(fmt.Errorf("%s is %s scoped, it can only be overridden by a provider", orig, orig.scope)).SetErrType(ErrTypeRegistry)
+	}
+	if vtyp.Kind() == reflect.Ptr && vtyp.Elem().Kind() == reflect.Struct {
+		if _, err := registry.checkTags(vtyp.Elem(), label); err != nil {
+			return err
+		}
+	}
+	o := &override{label: label, key: key, from: orig.vtyp, to: vtyp}
+	if err := o.checkSites(registry, orig); err != nil {
+		return err
+	}
+
+	registry.remove(orig)
+	var err error
+	if isProvider {
+		err = registry.declareProvider(label, key, replacement)
+	} else {
+		err = registry.declareValue(label, key, replacement)
+	}
+	if err != nil {
+		return err
+	}
+	// the replacement keeps the visibility and the scope of the original
+	h := registry.values[label][key]
+	h.module, h.private, h.scope = orig.module, orig.private, orig.scope
+	registry.overrides = append(registry.overrides, o)
+	log.Printf("[Godim] %s\n", o)
+	return nil
+}
+
+// checkOverrides checks the overrides against the services declared after them, once the declarations are over
+func (registry *Registry) checkOverrides() error {
+	for _, o := range registry.overrides {
+		if err := o.checkSites(registry, registry.values[o.label][o.key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkSites checks that the replacement can be injected wherever the original can be,
+// in the declared services but skip and in the pending conditional declarations
+func (o *override) checkSites(registry *Registry, skip *holder) error {
+	for _, h := range registry.holderList() {
+		if h == skip {
+			continue
+		}
+		var ftyp reflect.Type
+		if h.provider != nil {
+			ftyp = h.provider.fn.Type()
+		}
+		if err := o.checkConsumer(registry, h.String(), h.typ, ftyp); err != nil {
+			return err
+		}
+	}
+	for _, c := range registry.conditionals {
+		for _, v := range c.o {
+			typ := reflect.TypeOf(v)
+			var ftyp reflect.Type
+			if typ.Kind() == reflect.Func {
+				if checkProvider(typ) != nil {
+					// rejected when declared
+					continue
+				}
+				ftyp, typ = typ, typ.Out(0)
+			}
+			if typ.Kind() == reflect.Ptr {
+				typ = typ.Elem()
+			}
+			if err := o.checkConsumer(registry, fmt.Sprintf("%s:%s", c.label, typ), typ, ftyp); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkConsumer checks the inject tags of a struct type typ and the parameters of its provider type ftyp, which can be nil
+func (o *override) checkConsumer(registry *Registry, name string, typ, ftyp reflect.Type) error {
+	for i := 0; typ.Kind() == reflect.Struct && i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		itag, ok := field.Tag.Lookup(registry.inject)
+		if !ok {
+			continue
+		}
+		it, err := parseInjectTag(itag)
+		if err != nil {
+			return err
+		}
+		targets := it.auto && (o.from.AssignableTo(field.Type) || isLazyOf(field.Type, o.from))
+		targets = targets || (!it.auto && it.label == o.label && it.key == o.key)
+		if targets && !o.to.AssignableTo(field.Type) && !isLazyOf(field.Type, o.to) {
+			return newError(fmt.Errorf("%s can't override %s:%s, it can't be injected in field %s %s of %s", o.to, o.label, o.key, field.Name, field.Type, name)).SetErrType(ErrTypeRegistry)
+		}
+	}
+	for i := 0; ftyp != nil && i < ftyp.NumIn(); i++ {
+		if o.from.AssignableTo(ftyp.In(i)) && !o.to.AssignableTo(ftyp.In(i)) {
+			return newError(fmt.Errorf("%s can't override %s:%s, it can't be passed as parameter %d of provider of %s", o.to, o.label, o.key, i, name)).SetErrType(ErrTypeRegistry)
+		}
+	}
+	return nil
+}
+
+func isLazyOf(field, vtyp reflect.Type) bool {
+	target := lazyTarget(field)
+	return target != nil && vtyp.AssignableTo(target)
+}
+
+// remove forgets a holder declared in this registry, with its lifecycle and event registrations
+func (registry *Registry) remove(h *holder) {
+	delete(registry.values[h.label], h.key)
+	registry.holdersMu.Lock()
+	for i, other := range registry.holders {
+		if other == h {
+			registry.holders = append(registry.holders[:i], registry.holders[i+1:]...)
+			break
+		}
+	}
+	registry.holdersMu.Unlock()
+	if h.o == nil {
+		return
+	}
+	for i, c := range registry.components {
+		if sameInstance(c.o, h.o) {
+			registry.components = append(registry.components[:i], registry.components[i+1:]...)
+			break
+		}
+	}
+	if registry.eventSwitch != nil {
+		registry.eventSwitch.remove(h.o)
+	}
+}
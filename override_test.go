@@ -0,0 +1,208 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"strings"
+	"testing"
+)
+
+type Store interface {
+	Find() string
+}
+
+type RealStore struct {
+	inited bool
+}
+
+func (rs *RealStore) Find() string {
+	return "real"
+}
+
+func (rs *RealStore) OnInit() error {
+	rs.inited = true
+	return nil
+}
+
+type FakeStore struct{}
+
+func (fs *FakeStore) Find() string {
+	return "fake"
+}
+
+type StoreUser struct {
+	Store Store `inject:"default:RealStore"`
+}
+
+type StoreAutoUser struct {
+	Store Store `inject:""`
+}
+
+func TestGodim_Override_shouldReplaceDeclaredService(t *testing.T) {
+	g := Default()
+
+	rs := &RealStore{}
+	su := &StoreUser{}
+	sau := &StoreAutoUser{}
+	err := g.DeclareDefault(rs, su, sau)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.Override(defaultStr, "RealStore", &FakeStore{})
+	if err != nil {
+		t.Fatalf("Error while overriding: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if su.Store.Find() != "fake" || sau.Store.Find() != "fake" {
+		t.Fatal("The fake should be injected")
+	}
+	if rs.inited {
+		t.Fatal("The overridden service should not be initialized")
+	}
+	overrides := g.Overrides()
+	if len(overrides) != 1 || !strings.Contains(overrides[0], "default:RealStore") || !strings.Contains(overrides[0], "*godim.FakeStore") {
+		t.Fatalf("Wrong overrides diagnostic: %v.", overrides)
+	}
+	err = g.Override(defaultStr, "RealStore", &RealStore{})
+	if err == nil {
+		t.Fatal("Override is only possible in declaration phase")
+	}
+}
+
+func TestGodim_Override_shouldCheckInjectionSites(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(&RealStore{}, &StoreUser{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.Override(defaultStr, "RealStore", &EnglishGreeter{})
+	if err == nil {
+		t.Fatal("A replacement that can't be injected should be rejected")
+	}
+	err = g.Override(defaultStr, "Unknown", &FakeStore{})
+	if err == nil {
+		t.Fatal("Only declared services can be overridden")
+	}
+	built := 0
+	err = g.Override(defaultStr, "RealStore", func() *FakeStore {
+		built++
+		return &FakeStore{}
+	})
+	if err != nil {
+		t.Fatalf("A provider should be able to override: %s.", err)
+	}
+}
+
+type StorePair struct {
+	First  Store `inject:"default:RealStore"`
+	Second Store `inject:"default:RealStore"`
+}
+
+func TestGodim_Override_shouldKeepScope(t *testing.T) {
+	g := Default()
+
+	sp := &StorePair{}
+	err := g.DeclareScoped(ScopePrototype, defaultStr, func() *RealStore { return &RealStore{} })
+	if err != nil {
+		t.Fatalf("Error while declaring prototype: %s.", err)
+	}
+	err = g.DeclareDefault(sp)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.Override(defaultStr, "RealStore", &FakeStore{})
+	if err == nil {
+		t.Fatal("A prototype can only be overridden by a provider")
+	}
+	built := 0
+	err = g.Override(defaultStr, "RealStore", func() *FakeStore {
+		built++
+		return &FakeStore{}
+	})
+	if err != nil {
+		t.Fatalf("Error while overriding: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if sp.First.Find() != "fake" || sp.Second.Find() != "fake" || built != 2 {
+		t.Fatal("The replacement of a prototype should build an instance per consumer")
+	}
+}
+
+type BrokenStore struct {
+	FakeStore
+	URL string `config:"store.url,bogus"`
+}
+
+func TestGodim_Override_rejectedReplacementShouldKeepOriginal(t *testing.T) {
+	g := Default()
+
+	rs := &RealStore{}
+	su := &StoreUser{}
+	err := g.DeclareDefault(rs, su)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.Override(defaultStr, "RealStore", &BrokenStore{})
+	if err == nil {
+		t.Fatal("A replacement with invalid tags should be rejected")
+	}
+	err = g.Override(defaultStr, "RealStore", func() *BrokenStore { return &BrokenStore{} })
+	if err == nil {
+		t.Fatal("A provider of a replacement with invalid tags should be rejected")
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if su.Store != rs || !rs.inited || len(g.Overrides()) != 0 {
+		t.Fatal("A rejected override should leave the original service")
+	}
+}
+
+type RealStoreUser struct {
+	Store *RealStore `inject:"default:RealStore"`
+}
+
+func TestGodim_Override_shouldCheckLaterDeclarations(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(&RealStore{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareIf(OnMissing(defaultStr, "Unknown"), defaultStr, &RealStoreUser{})
+	if err != nil {
+		t.Fatalf("Error while declaring conditional: %s.", err)
+	}
+	err = g.Override(defaultStr, "RealStore", &FakeStore{})
+	if err == nil || !strings.Contains(err.Error(), "RealStoreUser") {
+		t.Fatalf("The conditional declarations should be checked, got %v.", err)
+	}
+
+	g = Default()
+	err = g.DeclareDefault(&RealStore{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.Override(defaultStr, "RealStore", &FakeStore{})
+	if err != nil {
+		t.Fatalf("Error while overriding: %s.", err)
+	}
+	err = g.Install(NewModule("users").Declare(defaultStr, &RealStoreUser{}))
+	if err != nil {
+		t.Fatalf("Error while installing module: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil || !strings.Contains(err.Error(), "RealStoreUser") {
+		t.Fatalf("The services declared after the override should be checked, got %v.", err)
+	}
+}
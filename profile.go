@@ -188,6 +188,21 @@ func (ap *AppProfile) validateTag(label, itag string) (string, error) {
 	return "", newError(fmt.Errorf("%s can't be injected in %s", elts[0], label)).SetErrType(ErrTypeProfile)
 }
 
+// validateInjection check that something declared in from can be injected in into
+func (ap *AppProfile) validateInjection(from, into string) error {
+	if ap.isDefault() {
+		return nil
+	}
+	p, err := ap.getProfile(from)
+	if err != nil {
+		return err
+	}
+	if p.canBeInjectedIn(into) {
+		return nil
+	}
+	return newError(fmt.Errorf("%s can't be injected in %s", from, into)).SetErrType(ErrTypeProfile)
+}
+
 func (ap *AppProfile) getProfile(label string) (*Profile, error) {
 	p := ap.profiles[label]
 	if p == nil {
@@ -5,10 +5,14 @@
 package godim
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
 	"sort"
 	"strings"
+	"sync"
+
+	"github.com/ekino/godim/internal/tag"
 )
 
 const (
@@ -23,16 +27,86 @@ type Registry struct {
 	config      string
 	appProfile  *AppProfile
 	values      map[string]map[string]*holder
+	holders     []*holder
+	holdersMu   sync.RWMutex
 	tags        map[reflect.Type]*TagConfig
-	inits       map[int]map[reflect.Type]reflect.Value
-	closers     map[reflect.Type]reflect.Value
-	eventSwitch *EventSwitch
+	components  []*component
+	initialized []*component
+	// prototypes are the components of the prototype instances built once the registry is running, closed first
+	prototypes   []*component
+	injected     bool
+	scheduled    map[*component]bool
+	lazyMu       sync.Mutex
+	eventSwitch  *EventSwitch
+	parent       *Registry
+	overrides    []*override
+	injections   []Injection
+	injectionsMu sync.Mutex
+	configFunc   func(key string, val reflect.Value) (interface{}, error)
+	conditionals []*conditional
+	decisions    []string
+	environments []string
+	modules      map[string]*Module
+	// configDefaults are the config defaults of the installed modules
+	configDefaults map[string]*configDefault
 }
 
 type holder struct {
-	o    interface{}
-	typ  reflect.Type
-	prio int
+	label    string
+	key      string
+	o        interface{}
+	typ      reflect.Type
+	vtyp     reflect.Type
+	prio     int
+	provider *provider
+	deps     []*holder
+	params   []*holder
+	lazy     []*holder
+	scope    string
+	template *holder
+	// module is the name of the module which declared the holder, if any
+	module string
+	// private holders can only be injected in the holders of their module
+	private bool
+	// registry is the registry which declared the holder, it builds and tracks its instances
+	registry *Registry
+}
+
+func (h *holder) String() string {
+	return h.label + ":" + h.key
+}
+
+// tagged tells if the config and inject tags of h.typ apply to h, values that are not pointers have no tags
+func (h *holder) tagged() bool {
+	return h.vtyp.Kind() == reflect.Ptr
+}
+
+// visibleFrom tells if h can be injected in consumer, which can be nil outside of any service
+func (h *holder) visibleFrom(consumer *holder) bool {
+	return !h.private || (consumer != nil && consumer.module == h.module)
+}
+
+// provider holds a constructor function declared in place of a struct.
+//
+// The function is called during the injection phase, its parameters being resolved from the registry by type.
+type provider struct {
+	fn reflect.Value
+}
+
+// Injection describes the resolution of an inject tag
+type Injection struct {
+	// Target is the label:key of the service holding the field
+	Target string
+	// Field is the name of the tagged field
+	Field string
+	// Tag is the inject tag of the field
+	Tag string
+	// Source is the label:key of the injected service, empty when an optional tag is unresolved
+	Source string
+	// Lazy is true when the field is resolved on first use
+	Lazy bool
+	// enforced is true when a profile rule was checked for the injection
+	enforced bool
 }
 
 // TagConfig internal configuration tag
@@ -48,20 +122,17 @@ func newRegistry() *Registry {
 		appProfile: newAppProfile(),
 		values:     make(map[string]map[string]*holder),
 		tags:       make(map[reflect.Type]*TagConfig),
-		inits:      make(map[int]map[reflect.Type]reflect.Value),
-		closers:    make(map[reflect.Type]reflect.Value),
 	}
 }
 
 func newRegistryFromConfig(config *Config) *Registry {
 	r := &Registry{
-		inject:     config.injectString,
-		config:     config.configString,
-		appProfile: config.appProfile,
-		values:     make(map[string]map[string]*holder),
-		tags:       make(map[reflect.Type]*TagConfig),
-		inits:      make(map[int]map[reflect.Type]reflect.Value),
-		closers:    make(map[reflect.Type]reflect.Value),
+		inject:       config.injectString,
+		config:       config.configString,
+		appProfile:   config.appProfile,
+		values:       make(map[string]map[string]*holder),
+		tags:         make(map[reflect.Type]*TagConfig),
+		environments: config.environments,
 	}
 	if config.activateES {
 		r.eventSwitch = config.eventSwitch
@@ -72,6 +143,9 @@ func newRegistryFromConfig(config *Config) *Registry {
 func (registry *Registry) declare(label string, o interface{}) error {
 
 	typ := reflect.TypeOf(o)
+	if typ.Kind() == reflect.Func {
+		return registry.declareProvider(label, "", o)
+	}
 	if typ.Kind() == reflect.Ptr {
 		// in case of a Ptr to interface
 		typ = reflect.ValueOf(o).Elem().Type()
@@ -80,18 +154,17 @@ func (registry *Registry) declare(label string, o interface{}) error {
 		return newError(fmt.Errorf(" %s is not a declared profile", label)).SetErrType(ErrTypeRegistry)
 
 	}
-	v, ok := registry.values[label]
-	if !ok {
-		v = make(map[string]*holder)
-		registry.values[label] = v
+	if typ.Kind() != reflect.Struct || (typ.Name() == "" && !isIdentifier(typ)) {
+		return newError(fmt.Errorf(" %s is not a named struct, use DeclareValue", typ)).SetErrType(ErrTypeRegistry)
 	}
+	v := registry.labelValues(label)
 	key := getKey(typ, o)
 	prio := getPriority(typ, o)
-	_, ok = v[key]
+	_, ok := v[key]
 	if ok {
 		return newError(fmt.Errorf(" %s already defined in registry", o)).SetErrType(ErrTypeRegistry)
 	}
-	v[key] = &holder{o: o, typ: typ, prio: prio}
+	registry.addHolder(v, &holder{label: label, key: key, o: o, typ: typ, vtyp: reflect.TypeOf(o), prio: prio})
 	err := registry.declareTags(typ, label)
 	if err != nil {
 		return err
@@ -99,6 +172,127 @@ func (registry *Registry) declare(label string, o interface{}) error {
 	return registry.declareInterfaces(o, typ)
 }
 
+// declareValue declare any value under an explicit key.
+//
+// A pointer to a struct is handled as in declare, other values have no tags but can still implement lifecycle interfaces
+func (registry *Registry) declareValue(label, key string, o interface{}) error {
+	if o == nil {
+		return newError(fmt.Errorf("can't declare a nil value for key %s", key)).SetErrType(ErrTypeRegistry)
+	}
+	if !registry.appProfile.validate(label) {
+		return newError(fmt.Errorf(" %s is not a declared profile", label)).SetErrType(ErrTypeRegistry)
+	}
+	v := registry.labelValues(label)
+	if _, ok := v[key]; ok {
+		return newError(fmt.Errorf(" %s already defined in registry", key)).SetErrType(ErrTypeRegistry)
+	}
+	vtyp := reflect.TypeOf(o)
+	typ := vtyp
+	isStruct := vtyp.Kind() == reflect.Ptr && vtyp.Elem().Kind() == reflect.Struct
+	if isStruct {
+		typ = vtyp.Elem()
+	}
+	registry.addHolder(v, &holder{label: label, key: key, o: o, typ: typ, vtyp: vtyp, prio: getPriority(typ, o)})
+	if isStruct {
+		err := registry.declareTags(typ, label)
+		if err != nil {
+			return err
+		}
+	}
+	return registry.declareInterfaces(o, typ)
+}
+
+// declareScoped declare providers whose instances live in scope
+func (registry *Registry) declareScoped(scope, label string, fn interface{}) error {
+	if scope == ScopeSingleton {
+		return registry.declare(label, fn)
+	}
+	if reflect.TypeOf(fn).Kind() != reflect.Func {
+		return newError(fmt.Errorf("%s scoped declaration of %T requires a provider", scope, fn)).SetErrType(ErrTypeRegistry)
+	}
+	err := registry.declareProvider(label, "", fn)
+	if err != nil {
+		return err
+	}
+	registry.holders[len(registry.holders)-1].scope = scope
+	return nil
+}
+
+// declareProvider declare a constructor function such as func(a *A, b B) (*C, error).
+//
+// The priority, and the key when it is empty, are read from a zero value of the built type.
+func (registry *Registry) declareProvider(label, key string, fn interface{}) error {
+	ftyp := reflect.TypeOf(fn)
+	if err := checkProvider(ftyp); err != nil {
+		return err
+	}
+	if !registry.appProfile.validate(label) {
+		return newError(fmt.Errorf(" %s is not a declared profile", label)).SetErrType(ErrTypeRegistry)
+	}
+	vtyp := ftyp.Out(0)
+	typ := vtyp.Elem()
+	zero := reflect.New(typ).Interface()
+	v := registry.labelValues(label)
+	if key == "" {
+		key = getKey(typ, zero)
+	}
+	if _, ok := v[key]; ok {
+		return newError(fmt.Errorf(" %s already defined in registry", key)).SetErrType(ErrTypeRegistry)
+	}
+	prio := getPriority(typ, zero)
+	registry.addHolder(v, &holder{label: label, key: key, typ: typ, vtyp: vtyp, prio: prio, provider: &provider{fn: reflect.ValueOf(fn)}})
+	return registry.declareTags(typ, label)
+}
+
+func checkProvider(ftyp reflect.Type) error {
+	if ftyp.IsVariadic() {
+		return newError(fmt.Errorf("provider %s can't be variadic", ftyp)).SetErrType(ErrTypeRegistry)
+	}
+	if ftyp.NumOut() < 1 || ftyp.NumOut() > 2 {
+		return newError(fmt.Errorf("provider %s must return a value and optionally an error", ftyp)).SetErrType(ErrTypeRegistry)
+	}
+	if ftyp.NumOut() == 2 && ftyp.Out(1) != errorType {
+		return newError(fmt.Errorf("provider %s second return value must be an error", ftyp)).SetErrType(ErrTypeRegistry)
+	}
+	out := ftyp.Out(0)
+	if out.Kind() != reflect.Ptr || out.Elem().Kind() != reflect.Struct {
+		return newError(fmt.Errorf("provider %s must return a pointer to a struct", ftyp)).SetErrType(ErrTypeRegistry)
+	}
+	return nil
+}
+
+func (registry *Registry) labelValues(label string) map[string]*holder {
+	v, ok := registry.values[label]
+	if !ok {
+		v = make(map[string]*holder)
+		registry.values[label] = v
+	}
+	return v
+}
+
+func (registry *Registry) addHolder(v map[string]*holder, h *holder) {
+	if h.scope == "" {
+		h.scope = ScopeSingleton
+	}
+	h.registry = registry
+	v[h.key] = h
+	registry.appendHolder(h)
+}
+
+// appendHolder adds h to the holders, prototype instances are appended while scopes read them concurrently
+func (registry *Registry) appendHolder(h *holder) {
+	registry.holdersMu.Lock()
+	defer registry.holdersMu.Unlock()
+	registry.holders = append(registry.holders, h)
+}
+
+// holderList returns a copy of the holders, safe to use while instances are appended
+func (registry *Registry) holderList() []*holder {
+	registry.holdersMu.RLock()
+	defer registry.holdersMu.RUnlock()
+	return append([]*holder(nil), registry.holders...)
+}
+
 var (
 	initType      = reflect.TypeOf((*Initializer)(nil)).Elem()
 	closeType     = reflect.TypeOf((*Closer)(nil)).Elem()
@@ -108,12 +302,15 @@ var (
 	recType       = reflect.TypeOf((*EventReceiver)(nil)).Elem()
 	interceptType = reflect.TypeOf((*EventInterceptor)(nil)).Elem()
 	finalizerType = reflect.TypeOf((*EventFinalizer)(nil)).Elem()
+	errorType     = reflect.TypeOf((*error)(nil)).Elem()
 )
 
+func isIdentifier(typ reflect.Type) bool {
+	return typ.Implements(keyType) || reflect.PtrTo(typ).Implements(keyType)
+}
+
 func getKey(typ reflect.Type, o interface{}) string {
-	ptyp := reflect.PtrTo(typ)
-	ok := typ.Implements(keyType)
-	if ptyp.Implements(keyType) || ok {
+	if isIdentifier(typ) {
 		return reflect.ValueOf(o).MethodByName("Key").Call([]reflect.Value{})[0].Interface().(string)
 	}
 	return strings.Split(typ.String(), ".")[1]
@@ -129,33 +326,27 @@ func getPriority(typ reflect.Type, o interface{}) int {
 }
 
 func (registry *Registry) declareInterfaces(o interface{}, typ reflect.Type) error {
-	prio := getPriority(typ, o)
-	_, exists := registry.inits[prio][typ]
-	if exists {
-		return newError(fmt.Errorf("OnInit Method already declared for type %s", typ)).SetErrType(ErrTypeRegistry)
-	}
-	ptyp := reflect.PtrTo(typ)
-	if ptyp.Implements(initType) {
-		_, ok := registry.inits[prio]
-		if !ok {
-			registry.inits[prio] = make(map[reflect.Type]reflect.Value)
+	for _, other := range registry.components {
+		if sameInstance(other.o, o) {
+			// same instance declared under several keys, its lifecycle is already tracked
+			return nil
 		}
-		registry.inits[prio][typ] = reflect.ValueOf(o).MethodByName("OnInit")
 	}
-	if ptyp.Implements(closeType) {
-		registry.closers[typ] = reflect.ValueOf(o).MethodByName("OnClose")
+	otyp := reflect.TypeOf(o)
+	if c := newComponent(o, typ); c != nil {
+		registry.components = append(registry.components, c)
 	}
 	if registry.eventSwitch != nil {
-		if ptyp.Implements(emitType) {
+		if otyp.Implements(emitType) {
 			registry.eventSwitch.AddEmitter(o.(Emitter))
 		}
-		if ptyp.Implements(recType) {
+		if otyp.Implements(recType) {
 			registry.eventSwitch.AddReceiver(o.(EventReceiver))
 		}
-		if ptyp.Implements(interceptType) {
+		if otyp.Implements(interceptType) {
 			registry.eventSwitch.AddInterceptor(o.(EventInterceptor))
 		}
-		if ptyp.Implements(finalizerType) {
+		if otyp.Implements(finalizerType) {
 			registry.eventSwitch.WithEventFinalizer(o.(EventFinalizer))
 		}
 	}
@@ -163,24 +354,74 @@ func (registry *Registry) declareInterfaces(o interface{}, typ reflect.Type) err
 }
 
 func (registry *Registry) declareTags(typ reflect.Type, label string) error {
+	tags, err := registry.checkTags(typ, label)
+	if err != nil {
+		return err
+	}
 	tc := registry.getTagConfig(typ)
+	for fieldname, ctag := range tags.configs {
+		tc.configs[fieldname] = ctag
+	}
+	for fieldname, itag := range tags.injects {
+		tc.injects[fieldname] = itag
+	}
+	return nil
+}
+
+// checkTags parses and validates the config and inject tags of typ declared in label, without recording them
+func (registry *Registry) checkTags(typ reflect.Type, label string) (*TagConfig, error) {
+	tc := &TagConfig{
+		configs: make(map[string]string),
+		injects: make(map[string]string),
+	}
 	for i := 0; i < typ.NumField(); i++ {
 		field := typ.Field(i)
 		tag := field.Tag
 		ctag := tag.Get(registry.config)
 		if len(ctag) > 0 {
+			ct, err := parseConfigTag(ctag)
+			if err != nil {
+				return nil, err
+			}
+			err = checkSection(field.Type, ct, registry.config, []reflect.Type{typ})
+			if err != nil {
+				return nil, err
+			}
 			tc.configs[field.Name] = ctag
 		}
-		itag := typ.Field(i).Tag.Get(registry.inject)
-		if len(itag) > 0 {
-			_, err := registry.appProfile.validateTag(label, itag)
+		itag, ok := tag.Lookup(registry.inject)
+		if ok {
+			it, err := parseInjectTag(itag)
 			if err != nil {
-				return newError(err).SetErrType(ErrTypeRegistry)
+				return nil, err
+			}
+			if !it.auto {
+				_, err := registry.appProfile.validateTag(label, it.target)
+				if err != nil {
+					return nil, newError(err).SetErrType(ErrTypeRegistry)
+				}
 			}
-			tc.injects[typ.Field(i).Name] = itag
+			tc.injects[field.Name] = itag
 		}
 	}
-	return nil
+	return tc, nil
+}
+
+// injectTag is the parsed form of an inject tag, see tag.Inject
+type injectTag struct {
+	target   string
+	label    string
+	key      string
+	auto     bool
+	optional bool
+}
+
+func parseInjectTag(itag string) (injectTag, error) {
+	t, err := tag.ParseInject(itag)
+	if err != nil {
+		return injectTag{}, newError(err).SetErrType(ErrTypeRegistry)
+	}
+	return injectTag{target: t.Target, label: t.Label, key: t.Key, auto: t.Auto, optional: t.Optional}, nil
 }
 
 func (registry *Registry) getTagConfig(typ reflect.Type) *TagConfig {
@@ -196,109 +437,513 @@ func (registry *Registry) getTagConfig(typ reflect.Type) *TagConfig {
 }
 
 func (registry *Registry) configure(f func(key string, val reflect.Value) (interface{}, error)) error {
+	if f != nil {
+		f = environmentConfig(registry.environments, f)
+	}
+	registry.configFunc = defaultsConfig(registry.configDefaults, f)
+	missing := &MultiError{}
 	for _, mv := range registry.values {
 		if mv == nil {
 			continue
 		}
 		for _, h := range mv {
-			typ := h.typ
-			tc := registry.tags[typ]
-			if tc == nil {
-				continue
-			}
-			elem := reflect.ValueOf(h.o).Elem()
-			for fieldname, key := range tc.configs {
-				err := setFieldOnValue(elem, fieldname, key, f)
-				if err != nil {
-					return err
-				}
+			err := registry.configureDeclared(h, missing)
+			if err != nil {
+				return err
 			}
 		}
 	}
+	// the required keys of the conditional services are reported with the other ones
+	err := registry.declareConditionals(missing)
+	if err != nil {
+		return err
+	}
+	if len(missing.Errors) > 0 {
+		return newError(missing).SetErrType(ErrTypeRegistry)
+	}
+	return nil
+}
+
+// configureDeclared sets the config fields of a declared holder, the required keys without value are added to missing
+func (registry *Registry) configureDeclared(h *holder, missing *MultiError) error {
+	if h.o == nil {
+		// built by a provider during injection phase, its required keys are checked on a zero value
+		h = &holder{label: h.label, key: h.key, o: reflect.New(h.typ).Interface(), typ: h.typ, vtyp: h.vtyp}
+	}
+	return registry.configureHolder(h, registry.configFunc, missing)
+}
+
+// configureHolder sets the config fields of h, the required keys without value are added to missing
+func (registry *Registry) configureHolder(h *holder, f func(key string, val reflect.Value) (interface{}, error), missing *MultiError) error {
+	tc := registry.tags[h.typ]
+	if tc == nil || !h.tagged() {
+		return nil
+	}
+	elem := reflect.ValueOf(h.o).Elem()
+	fieldnames := make([]string, 0, len(tc.configs))
+	for fieldname := range tc.configs {
+		fieldnames = append(fieldnames, fieldname)
+	}
+	sort.Strings(fieldnames)
+	report := func(err error) {
+		missing.add(h.String(), err)
+	}
+	for _, fieldname := range fieldnames {
+		ct, err := parseConfigTag(tc.configs[fieldname])
+		if err != nil {
+			return err
+		}
+		err = registry.setFieldOnValue(elem, fieldname, ct, f, report)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-func setFieldOnValue(v reflect.Value, fieldname, key string, f func(key string, val reflect.Value) (interface{}, error)) error {
+// setFieldOnValue sets the value of a config key in a field, converted to the field type, see ConvertValue.
+//
+// A nil value leaves the field unchanged, unless the tag has a default or is required, required keys without value are reported.
+// Struct sections are set field by field, their keys prefixed by the key of the section.
+// f can be nil when there is no configuration function
+func (registry *Registry) setFieldOnValue(v reflect.Value, fieldname string, ct configTag, f func(key string, val reflect.Value) (interface{}, error), report func(err error)) error {
 	field := v.FieldByName(fieldname)
-	toSet, err := f(key, field)
+	if !field.CanSet() {
+		return newError(fmt.Errorf("config key %s: field %s of %s is not exported", ct.key, fieldname, v.Type())).SetErrType(ErrTypeRegistry)
+	}
+	if st, ok := sectionType(field.Type(), registry.config); ok {
+		return registry.setSection(field, st, ct.key, f, report)
+	}
+	var toSet interface{}
+	var err error
+	if f != nil {
+		toSet, err = f(ct.key, field)
+	}
+	if (err != nil || toSet == nil) && (ct.hasDefault || ct.required) {
+		if ct.required {
+			if err != nil {
+				report(fmt.Errorf("%w: %s (%s)", errMissingConfig, ct.key, err))
+			} else {
+				report(fmt.Errorf("%w: %s", errMissingConfig, ct.key))
+			}
+			return nil
+		}
+		toSet, err = ct.def, nil
+	}
 	if err != nil {
 		return err
 	}
-	field.Set(reflect.ValueOf(toSet))
+	if toSet == nil {
+		return nil
+	}
+	cv, err := convertValue(toSet, field.Type(), registry.config)
+	if err != nil {
+		return newError(fmt.Errorf("config key %s: can't set %T in field %s of type %s: %w", ct.key, toSet, fieldname, field.Type(), err)).SetErrType(ErrTypeRegistry)
+	}
+	field.Set(cv)
 	return nil
 }
 
-func (registry *Registry) injection() error {
-	for _, mv := range registry.values {
-		if mv == nil {
+// setSection sets the fields of the section st held by field, a nil pointer is replaced by a new section
+func (registry *Registry) setSection(field reflect.Value, st reflect.Type, prefix string, f func(key string, val reflect.Value) (interface{}, error), report func(err error)) error {
+	if field.Kind() == reflect.Ptr {
+		if field.IsNil() {
+			field.Set(reflect.New(st))
+		}
+		field = field.Elem()
+	}
+	for i := 0; i < st.NumField(); i++ {
+		ctag := st.Field(i).Tag.Get(registry.config)
+		if ctag == "" {
 			continue
 		}
-		for _, h := range mv {
-			typ := h.typ
-			tc := registry.tags[typ]
-			if tc == nil {
-				continue
-			}
-			elem := reflect.ValueOf(h.o).Elem()
-			for fieldname, key := range tc.injects {
-				elts := strings.Split(key, ":")
-				toInject := registry.getElement(elts[0], elts[1])
-				if toInject != nil {
-					elem.FieldByName(fieldname).Set(reflect.ValueOf(toInject))
-				}
+		ct, err := parseConfigTag(ctag)
+		if err != nil {
+			return err
+		}
+		ct.key = prefix + "." + ct.key
+		err = registry.setFieldOnValue(field, st.Field(i).Name, ct, f, report)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errMissingConfig is the error of a required config key without value
+var errMissingConfig = errors.New("required config key has no value")
+
+// configTag is the parsed form of a config tag, see tag.Config
+type configTag struct {
+	key        string
+	def        string
+	hasDefault bool
+	required   bool
+}
 
+func parseConfigTag(ctag string) (configTag, error) {
+	t, err := tag.ParseConfig(ctag)
+	if err != nil {
+		return configTag{}, newError(err).SetErrType(ErrTypeRegistry)
+	}
+	return configTag{key: t.Key, def: t.Def, hasDefault: t.HasDefault, required: t.Required}, nil
+}
+
+func (registry *Registry) injection() error {
+	res := newResolution(nil)
+	lazyOnly := registry.lazyOnly()
+	for _, h := range registry.holderList() {
+		if h.scope == ScopeSingleton && !lazyOnly[h] {
+			err := registry.build(res, h)
+			if err != nil {
+				return err
 			}
 		}
 	}
-	return nil
+	// prototype instances are appended to holders and injected when built
+	for _, h := range registry.holderList() {
+		if h.scope != ScopeSingleton || h.template != nil || h.o == nil {
+			// lazily built providers are injected on first use
+			continue
+		}
+		err := registry.injectHolder(res, h)
+		if err != nil {
+			return err
+		}
+	}
+	registry.injected = true
+	// the services of a running parent built for this registry are initialized now
+	err := res.finish(nil)
+	if err != nil {
+		return err
+	}
+	return registry.checkCycles()
 }
 
-func (registry *Registry) getElement(label, key string) interface{} {
-	m := registry.values[label]
-	if m == nil {
+func (registry *Registry) injectHolder(res *resolution, h *holder) error {
+	tc := registry.tags[h.typ]
+	if tc == nil || !h.tagged() {
 		return nil
 	}
-	for k, h := range m {
-		if key == k {
-			return h.o
+	elem := reflect.ValueOf(h.o).Elem()
+	for fieldname, itag := range tc.injects {
+		err := registry.injectField(res, h, elem, fieldname, itag)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
+func (registry *Registry) injectField(res *resolution, h *holder, elem reflect.Value, fieldname, itag string) error {
+	field := elem.FieldByName(fieldname)
+	it, err := parseInjectTag(itag)
+	if err != nil {
+		return err
+	}
+	dep, lazy, err := registry.fieldDep(h, fieldname, field.Type(), it)
+	if err != nil {
+		return err
+	}
+	if dep == nil {
+		if it.optional {
+			registry.recordInjection(res, h, Injection{Target: h.String(), Field: fieldname, Tag: itag})
+			return nil
+		}
+		return newError(fmt.Errorf("unresolved injection of %s in field %s of %s (%s)", it.target, fieldname, h, h.typ)).SetErrType(ErrTypeInjection)
+	}
+	if lazy {
+		err = registry.bindLazy(h, dep, field)
+	} else {
+		err = registry.setField(res, h, field, fieldname, it, dep)
+	}
+	if err != nil {
+		return err
+	}
+	// explicit targets are checked by declareTags, autowired ones by autowire, unless the profile is the default one
+	enforced := !registry.appProfile.isDefault()
+	registry.recordInjection(res, h, Injection{Target: h.String(), Field: fieldname, Tag: itag, Source: dep.String(), Lazy: lazy, enforced: enforced})
+	return nil
+}
+
+// recordInjection keeps i for Injections, unless h is an instance built in a scope or on demand once the registry is running,
+// whose injections would be recorded on every resolution for the life of the application
+func (registry *Registry) recordInjection(res *resolution, h *holder, i Injection) {
+	if h.template != nil && (res.scope != nil || registry.scheduled != nil) {
+		return
+	}
+	registry.injectionsMu.Lock()
+	defer registry.injectionsMu.Unlock()
+	registry.injections = append(registry.injections, i)
+}
+
+func (registry *Registry) setField(res *resolution, h *holder, field reflect.Value, fieldname string, it injectTag, dep *holder) error {
+	dep, err := registry.resolve(res, h, dep)
+	if err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dep.o)
+	if !v.Type().AssignableTo(field.Type()) {
+		return newError(fmt.Errorf("%s of type %s can't be injected in field %s %s of %s", it.target, v.Type(), fieldname, field.Type(), h)).SetErrType(ErrTypeInjection)
+	}
+	field.Set(v)
+	h.deps = append(h.deps, dep)
+	return nil
+}
+
+// fieldDep finds the holder to inject in a field and tells if it must be injected lazily,
+// which is the case when the field is a lazy one not directly assignable from the holder value
+func (registry *Registry) fieldDep(h *holder, fieldname string, typ reflect.Type, it injectTag) (*holder, bool, error) {
+	target := lazyTarget(typ)
+	if it.auto {
+		dep, err := registry.autowire(h, fieldname, typ, it.optional || target != nil)
+		if err != nil || dep != nil || target == nil {
+			return dep, false, err
+		}
+		dep, err = registry.autowire(h, fieldname, target, it.optional)
+		return dep, dep != nil, err
+	}
+	dep := registry.getHolder(it.label, it.key)
+	if dep != nil && !dep.visibleFrom(h) {
+		return nil, false, newError(fmt.Errorf("%s is private to module %s, it can't be injected in %s", dep, dep.module, h)).SetErrType(ErrTypeProfile)
+	}
+	if dep == nil || target == nil || dep.vtyp.AssignableTo(typ) {
+		return dep, false, nil
+	}
+	return dep, true, nil
+}
+
+// autowire find the only holder assignable to the type of a field tagged with auto injection
+//
+// A nil holder is returned when nothing matches an optional field
+func (registry *Registry) autowire(h *holder, fieldname string, typ reflect.Type, optional bool) (*holder, error) {
+	candidates := registry.findByType(h, typ)
+	switch len(candidates) {
+	case 0:
+		if optional {
+			return nil, nil
+		}
+		return nil, newError(fmt.Errorf("no declared service assignable to %s for field %s of %s", typ, fieldname, h)).SetErrType(ErrTypeInjection)
+	case 1:
+	default:
+		return nil, newError(fmt.Errorf("ambiguous injection of %s in field %s of %s, candidates are: %s", typ, fieldname, h, holderNames(candidates))).SetErrType(ErrTypeInjection)
+	}
+	dep := candidates[0]
+	return dep, registry.appProfile.validateInjection(dep.label, h.label)
+}
+
+// build calls the provider of a singleton holder once.
+//
+// Built values go through configuration and lifecycle declaration like any declared struct.
+func (registry *Registry) build(res *resolution, h *holder) error {
+	if h.provider == nil || h.o != nil {
+		return nil
+	}
+	if err := res.enter(h); err != nil {
+		return err
+	}
+	defer res.leave()
+
+	o, deps, err := registry.call(res, h)
+	if err != nil {
+		return err
+	}
+	h.o = o
+	h.params = deps
+	h.deps = append(h.deps, deps...)
+	if registry.injected {
+		// built on first use of a lazy field, after the injection of the other holders
+		err = registry.injectHolder(res, h)
+		if err != nil {
+			return err
+		}
+	}
+	return registry.declareInterfaces(h.o, h.typ)
+}
+
+// call calls the provider of a holder, resolving its parameters by type, and configures the result
+func (registry *Registry) call(res *resolution, h *holder) (interface{}, []*holder, error) {
+	fn := h.provider.fn
+	ftyp := fn.Type()
+	args := make([]reflect.Value, ftyp.NumIn())
+	deps := make([]*holder, ftyp.NumIn())
+	for i := range args {
+		dep, err := registry.resolveParam(res, h, ftyp.In(i))
+		if err != nil {
+			return nil, nil, err
+		}
+		args[i] = reflect.ValueOf(dep.o)
+		deps[i] = dep
+	}
+	ret := fn.Call(args)
+	if len(ret) > 1 && !ret[1].IsNil() {
+		return nil, nil, newError(fmt.Errorf("provider of %s failed: %w", h, ret[1].Interface().(error))).SetErrType(ErrTypeInjection)
+	}
+	if ret[0].IsNil() {
+		return nil, nil, newError(fmt.Errorf("provider of %s returned nil", h)).SetErrType(ErrTypeInjection)
+	}
+	o := ret[0].Interface()
+	missing := &MultiError{}
+	err := registry.configureHolder(&holder{label: h.label, key: h.key, o: o, typ: h.typ, vtyp: h.vtyp}, registry.configFunc, missing)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(missing.Errors) > 0 {
+		return nil, nil, newError(missing).SetErrType(ErrTypeRegistry)
+	}
+	return o, deps, nil
+}
+
+// resolveParam find the only holder assignable to typ and resolve it according to its scope
+func (registry *Registry) resolveParam(res *resolution, h *holder, typ reflect.Type) (*holder, error) {
+	candidates := registry.findByType(h, typ)
+	switch len(candidates) {
+	case 0:
+		return nil, newError(fmt.Errorf("no declared service of type %s for provider of %s", typ, h)).SetErrType(ErrTypeInjection)
+	case 1:
+	default:
+		return nil, newError(fmt.Errorf("several services of type %s for provider of %s: %s", typ, h, holderNames(candidates))).SetErrType(ErrTypeInjection)
+	}
+	dep := candidates[0]
+	err := registry.appProfile.validateInjection(dep.label, h.label)
+	if err != nil {
+		return nil, err
+	}
+	return registry.resolve(res, h, dep)
+}
+
+// findByType returns every declared holder but h, which can be nil, whose value is assignable to typ.
+//
+// Private holders of other modules are left out.
+//
+// The parent registry is searched only when nothing matches locally
+func (registry *Registry) findByType(h *holder, typ reflect.Type) []*holder {
+	var candidates []*holder
+	for _, c := range registry.holderList() {
+		if h != nil && (c == h || c == h.template) {
+			continue
+		}
+		if c.template == nil && c.visibleFrom(h) && c.vtyp.AssignableTo(typ) {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 && registry.parent != nil {
+		return registry.parent.findByType(h, typ)
+	}
+	return candidates
+}
+
+func holderNames(hs []*holder) string {
+	names := make([]string, len(hs))
+	for i, h := range hs {
+		names[i] = h.String()
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func (registry *Registry) getElement(label, key string) interface{} {
+	h := registry.getHolder(label, key)
+	if h == nil {
+		return nil
+	}
+	return h.o
+}
+
+// getHolder returns the holder declared under label and key, falling back to the parent registry
+func (registry *Registry) getHolder(label, key string) *holder {
+	h := registry.values[label][key]
+	if h == nil && registry.parent != nil {
+		return registry.parent.getHolder(label, key)
+	}
+	return h
+}
+
+// initializeAll calls OnInit in dependency order and records that order for closeAll.
+//
+// When an OnInit fails, the services already initialized are closed in reverse order
+// and the returned MultiError holds the init failure followed by the rollback failures
 func (registry *Registry) initializeAll() error {
-	// Sort by priority
-	priorities := make([]int, len(registry.inits))
-	i := 0
-	for k := range registry.inits {
-		priorities[i] = k
-		i++
-	}
-	sort.Ints(priorities)
-
-	for _, p := range priorities {
-		for _, m := range registry.inits[p] {
-			ret := m.Call([]reflect.Value{})
-			if len(ret) > 0 {
-				err := ret[0]
-				if !err.IsNil() {
-					return err.Interface().(error)
-				}
+	order := registry.initOrder()
+	registry.scheduled = make(map[*component]bool, len(order))
+	for _, c := range order {
+		registry.scheduled[c] = true
+	}
+	for _, c := range order {
+		if c.onInit.IsValid() {
+			err := callLifecycle(c.onInit)
+			if err != nil {
+				errs := &MultiError{}
+				errs.add(registry.componentName(c), err)
+				registry.closeInitialized(errs)
+				return newError(errs).SetErrType(ErrTypeGodim)
 			}
 		}
+		registry.initialized = append(registry.initialized, c)
 	}
 	return nil
 }
 
+// closeAll calls OnClose in the reverse order of initialization,
+// so that consumers are closed before the services they depend on.
+//
+// Every closer is called, failures are gathered in a MultiError
 func (registry *Registry) closeAll() error {
-	for _, m := range registry.closers {
-		ret := m.Call([]reflect.Value{})
-		if len(ret) > 0 {
-			err := ret[0]
-			if !err.IsNil() {
-				return err.Interface().(error)
+	errs := &MultiError{}
+	registry.closeInitialized(errs)
+	if len(errs.Errors) > 0 {
+		return newError(errs).SetErrType(ErrTypeGodim)
+	}
+	return nil
+}
+
+func (registry *Registry) closeInitialized(errs *MultiError) {
+	// prototypes built on demand depend on the initialized components
+	for i := len(registry.prototypes) - 1; i >= 0; i-- {
+		c := registry.prototypes[i]
+		if c.onClose.IsValid() {
+			err := callLifecycle(c.onClose)
+			if err != nil {
+				errs.add(c.name, err)
+			}
+		}
+	}
+	registry.prototypes = nil
+	for i := len(registry.initialized) - 1; i >= 0; i-- {
+		c := registry.initialized[i]
+		if c.onClose.IsValid() {
+			err := callLifecycle(c.onClose)
+			if err != nil {
+				errs.add(registry.componentName(c), err)
 			}
 		}
 	}
+	registry.initialized = nil
+}
+
+// newComponent returns the component of o, nil when it has no lifecycle method
+func newComponent(o interface{}, typ reflect.Type) *component {
+	otyp := reflect.TypeOf(o)
+	c := &component{o: o, typ: typ, prio: getPriority(typ, o)}
+	if otyp.Implements(initType) {
+		c.onInit = reflect.ValueOf(o).MethodByName("OnInit")
+	}
+	if otyp.Implements(closeType) {
+		c.onClose = reflect.ValueOf(o).MethodByName("OnClose")
+	}
+	if !c.onInit.IsValid() && !c.onClose.IsValid() {
+		return nil
+	}
+	return c
+}
+
+func callLifecycle(m reflect.Value) error {
+	ret := m.Call([]reflect.Value{})
+	if len(ret) > 0 {
+		err := ret[0]
+		if !err.IsNil() {
+			return err.Interface().(error)
+		}
+	}
 	return nil
 }
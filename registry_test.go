@@ -213,3 +213,159 @@ func TestPriorization(t *testing.T) {
 		t.Fatalf("Priorization didn't work as expected")
 	}
 }
+
+func TestParseInjectTag(t *testing.T) {
+	it, err := parseInjectTag("service:Cache,optional")
+	if err != nil {
+		t.Fatalf("error while parsing tag %s", err)
+	}
+	if it.label != "service" || it.key != "Cache" || !it.optional || it.auto {
+		t.Fatalf("wrong tag parsing %+v", it)
+	}
+	it, err = parseInjectTag("auto")
+	if err != nil || !it.auto || it.optional {
+		t.Fatalf("wrong tag parsing %+v", it)
+	}
+	_, err = parseInjectTag("service:Cache,maybe")
+	if err == nil {
+		t.Fatalf("unknown option must be rejected")
+	}
+}
+
+func TestParseConfigTag(t *testing.T) {
+	ct, err := parseConfigTag("db.hosts, default=a,b")
+	if err != nil {
+		t.Fatalf("error while parsing tag %s", err)
+	}
+	if ct.key != "db.hosts" || !ct.hasDefault || ct.def != "a,b" || ct.required {
+		t.Fatalf("wrong tag parsing %+v", ct)
+	}
+	ct, err = parseConfigTag("db.password,required")
+	if err != nil || !ct.required || ct.hasDefault {
+		t.Fatalf("wrong tag parsing %+v", ct)
+	}
+	for _, tag := range []string{"db.port,maybe", ",required", "db.port,required,default=1"} {
+		_, err = parseConfigTag(tag)
+		if err == nil {
+			t.Fatalf("%s must be rejected", tag)
+		}
+	}
+}
+
+var initCalls []string
+
+type Leaf struct{}
+
+func (l *Leaf) OnInit() error {
+	initCalls = append(initCalls, "leaf")
+	return nil
+}
+
+func (l *Leaf) Priority() int {
+	return 10
+}
+
+type Middle struct {
+	Leaf *Leaf `inject:""`
+}
+
+type Root struct {
+	Middle *Middle `inject:""`
+}
+
+func (r *Root) OnInit() error {
+	initCalls = append(initCalls, "root")
+	return nil
+}
+
+type Other struct{}
+
+func (o *Other) OnInit() error {
+	initCalls = append(initCalls, "other")
+	return nil
+}
+
+func TestTopologicalInitialization(t *testing.T) {
+	r := newRegistry()
+	r.appProfile.lock()
+	initCalls = nil
+
+	for _, o := range []interface{}{&Root{}, &Middle{}, &Other{}, &Leaf{}} {
+		err := r.declare(defaultStr, o)
+		if err != nil {
+			t.Fatalf("declaration error %s", err)
+		}
+	}
+	err := r.injection()
+	if err != nil {
+		t.Fatalf("injection error %s", err)
+	}
+	err = r.initializeAll()
+	if err != nil {
+		t.Fatalf("initialization error %s", err)
+	}
+	// root waits for leaf through middle, other has no dependency and a lower priority than leaf
+	expected := []string{"other", "leaf", "root"}
+	if !reflect.DeepEqual(initCalls, expected) {
+		t.Fatalf("wrong initialization order %v, expected %v", initCalls, expected)
+	}
+}
+
+var closeCalls []string
+
+type Pool struct{}
+
+func (p *Pool) OnClose() error {
+	closeCalls = append(closeCalls, "pool")
+	return nil
+}
+
+type Repo struct {
+	Pool *Pool `inject:""`
+}
+
+func (r *Repo) OnInit() error {
+	return nil
+}
+
+func (r *Repo) OnClose() error {
+	closeCalls = append(closeCalls, "repo")
+	return nil
+}
+
+type Svc struct {
+	Repo *Repo `inject:""`
+}
+
+func (s *Svc) OnClose() error {
+	closeCalls = append(closeCalls, "svc")
+	return nil
+}
+
+func TestReverseOrderClosing(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		r := newRegistry()
+		r.appProfile.lock()
+		closeCalls = nil
+
+		for _, o := range []interface{}{&Pool{}, &Svc{}, &Repo{}} {
+			err := r.declare(defaultStr, o)
+			if err != nil {
+				t.Fatalf("declaration error %s", err)
+			}
+		}
+		if err := r.injection(); err != nil {
+			t.Fatalf("injection error %s", err)
+		}
+		if err := r.initializeAll(); err != nil {
+			t.Fatalf("initialization error %s", err)
+		}
+		if err := r.closeAll(); err != nil {
+			t.Fatalf("closing error %s", err)
+		}
+		expected := []string{"svc", "repo", "pool"}
+		if !reflect.DeepEqual(closeCalls, expected) {
+			t.Fatalf("wrong closing order %v, expected %v", closeCalls, expected)
+		}
+	}
+}
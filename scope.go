@@ -0,0 +1,354 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"sync"
+)
+
+const (
+	// ScopeSingleton one instance shared by every injection point, the default scope
+	ScopeSingleton = "singleton"
+	// ScopePrototype a fresh instance built by its provider for each injection point
+	ScopePrototype = "prototype"
+)
+
+// Scope holds the instances of the services declared in a custom scope, like a http request or a job run.
+//
+// Instances are built on demand by Get and closed together by Close.
+// A Scope can be used by several goroutines, its lock is never held while calling providers or lifecycle methods.
+type Scope struct {
+	name      string
+	registry  *Registry
+	instances map[*holder]*scoped
+	created   []*holder
+	closed    bool
+	mu        sync.Mutex
+}
+
+// scoped is the instance of a scoped service, ready is closed once it is initialized or its resolution failed
+type scoped struct {
+	tmpl   *holder
+	res    *resolution
+	inst   *holder
+	err    error
+	inited bool
+	ready  chan struct{}
+}
+
+// resolution carries the state of a dependency resolution
+type resolution struct {
+	scope *Scope
+	// building holds the providers being called, in call order
+	building []*holder
+	// locked holds the registries whose lazy resolution lock is held by the resolution
+	locked map[*Registry]bool
+	// entries holds the scoped instances built by the resolution, released by finish
+	entries []*scoped
+	// inits holds the instances built in the scope, in creation order, initialized by finish
+	inits []*holder
+	// late holds the shared components built while their registry is running, initialized by finish
+	late []lateInit
+}
+
+func newResolution(sc *Scope) *resolution {
+	return &resolution{scope: sc, locked: make(map[*Registry]bool)}
+}
+
+// lock takes the lazy resolution lock of registry unless the resolution already holds it, the returned func releases it
+func (res *resolution) lock(registry *Registry) func() {
+	if res.locked[registry] {
+		return func() {}
+	}
+	registry.lazyMu.Lock()
+	res.locked[registry] = true
+	return func() {
+		delete(res.locked, registry)
+		registry.lazyMu.Unlock()
+	}
+}
+
+// enter pushes h on the building stack, it fails with the cycle path when h is already being built
+func (res *resolution) enter(h *holder) error {
+	for i, b := range res.building {
+		if b == h {
+			return newError(fmt.Errorf("provider cycle detected: %s -> %s", holderPath(res.building[i:]), h)).SetErrType(ErrTypeInjection)
+		}
+	}
+	res.building = append(res.building, h)
+	return nil
+}
+
+// leave pops the holder pushed by the last enter
+func (res *resolution) leave() {
+	res.building = res.building[:len(res.building)-1]
+}
+
+// finish initializes the instances built by the resolution, which must not hold any lock anymore,
+// so that their OnInit can resolve other services. err is the error of the resolution, if any
+func (res *resolution) finish(err error) error {
+	if err == nil {
+		// shared components first, they can't depend on the instances of a scope
+		for _, l := range res.late {
+			err = l.initialize()
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		for _, inst := range res.inits {
+			err = res.scope.track(inst)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if res.scope != nil {
+		res.scope.release(res, err)
+	}
+	return err
+}
+
+func newScope(name string, registry *Registry) *Scope {
+	return &Scope{
+		name:      name,
+		registry:  registry,
+		instances: make(map[*holder]*scoped),
+	}
+}
+
+// Name returns the name of the scope
+func (sc *Scope) Name() string {
+	return sc.name
+}
+
+// Get returns the instance declared under label and key.
+//
+// Services of this scope are built and initialized on first call, prototypes are built on each call
+// and singletons are shared with the whole application. Services private to a module can't be fetched.
+func (sc *Scope) Get(label, key string) (interface{}, error) {
+	if err := sc.checkOpen(); err != nil {
+		return nil, err
+	}
+	h := sc.registry.getHolder(label, key)
+	if h == nil {
+		return nil, newError(fmt.Errorf("%s:%s is not declared", label, key)).SetErrType(ErrTypeRegistry)
+	}
+	if !h.visibleFrom(nil) {
+		return nil, newError(fmt.Errorf("%s is private to module %s", h, h.module)).SetErrType(ErrTypeRegistry)
+	}
+	if h.scope != ScopeSingleton && h.scope != ScopePrototype && h.scope != sc.name {
+		return nil, newError(fmt.Errorf("%s is %s scoped and can't be resolved in a %s scope", h, h.scope, sc.name)).SetErrType(ErrTypeRegistry)
+	}
+	res := newResolution(sc)
+	inst, err := sc.registry.resolve(res, h, h)
+	err = res.finish(err)
+	if err != nil {
+		return nil, err
+	}
+	return inst.o, nil
+}
+
+// Close calls OnClose on every instance built by this scope, in reverse order of creation
+func (sc *Scope) Close() error {
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		return nil
+	}
+	sc.closed = true
+	created := sc.created
+	sc.mu.Unlock()
+	errs := &MultiError{}
+	for i := len(created) - 1; i >= 0; i-- {
+		if c, ok := created[i].o.(Closer); ok {
+			err := c.OnClose()
+			if err != nil {
+				errs.add(created[i].String(), err)
+			}
+		}
+	}
+	if len(errs.Errors) > 0 {
+		return newError(errs).SetErrType(ErrTypeGodim)
+	}
+	return nil
+}
+
+func (sc *Scope) checkOpen() error {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if sc.closed {
+		return newError(fmt.Errorf("scope %s is closed", sc.name)).SetErrType(ErrTypeRegistry)
+	}
+	return nil
+}
+
+// get returns the instance of tmpl in the scope, building it on first call.
+//
+// An instance being built by another resolution is waited for, until that resolution is finished
+func (sc *Scope) get(res *resolution, tmpl *holder) (*holder, error) {
+	sc.mu.Lock()
+	if sc.closed {
+		sc.mu.Unlock()
+		return nil, newError(fmt.Errorf("scope %s is closed", sc.name)).SetErrType(ErrTypeRegistry)
+	}
+	s, ok := sc.instances[tmpl]
+	if !ok {
+		s = &scoped{tmpl: tmpl, res: res, ready: make(chan struct{})}
+		sc.instances[tmpl] = s
+		res.entries = append(res.entries, s)
+	}
+	sc.mu.Unlock()
+	if ok {
+		if s.res != res {
+			<-s.ready
+			return s.inst, s.err
+		}
+		if s.inst == nil {
+			// still being built by this resolution
+			return nil, res.enter(tmpl)
+		}
+		return s.inst, nil
+	}
+	inst, err := sc.registry.instantiate(res, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	s.inst = inst
+	return inst, nil
+}
+
+// track initializes an instance built in the scope and keeps it for Close
+func (sc *Scope) track(inst *holder) error {
+	if i, ok := inst.o.(Initializer); ok {
+		err := i.OnInit()
+		if err != nil {
+			return err
+		}
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.created = append(sc.created, inst)
+	if s, ok := sc.instances[inst.template]; ok && s.inst == inst {
+		// ready for the other resolutions, like the ones run by the OnInit of its consumers
+		s.inited = true
+		close(s.ready)
+	}
+	return nil
+}
+
+// release wakes up the resolutions waiting for the instances built by res and not initialized.
+//
+// When res failed, these instances are dropped, a later Get builds them again
+func (sc *Scope) release(res *resolution, err error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for _, s := range res.entries {
+		if s.inited {
+			continue
+		}
+		if err != nil {
+			delete(sc.instances, s.tmpl)
+			s.inst, s.err = nil, err
+		}
+		close(s.ready)
+	}
+}
+
+// resolve returns the holder whose value is injected in consumer for dep, according to the scope of dep
+func (registry *Registry) resolve(res *resolution, consumer, dep *holder) (*holder, error) {
+	owner := dep.registry
+	// prototypes built in a scope are tracked by the scope, the registry of their template is left untouched
+	shared := dep.scope == ScopeSingleton || (dep.scope == ScopePrototype && res.scope == nil)
+	if shared && !res.locked[owner] && (owner != registry || res.scope != nil) {
+		return owner.resolveShared(res, consumer, dep)
+	}
+	switch dep.scope {
+	case ScopeSingleton:
+		return dep, registry.build(res, dep)
+	case ScopePrototype:
+		return owner.instantiate(res, dep)
+	}
+	if res.scope == nil || res.scope.name != dep.scope {
+		return nil, newError(fmt.Errorf("%s is %s scoped and can't be injected in %s outside of a %s scope", dep, dep.scope, consumer, dep.scope)).SetErrType(ErrTypeInjection)
+	}
+	return res.scope.get(res, dep)
+}
+
+// resolveShared resolves dep, declared by registry, for a consumer of a child registry or of a scope.
+//
+// The instances are built and tracked by registry under its lazy resolution lock, as scopes can run concurrently,
+// and initialized by the finish of res when it is running, so that closing the child or the scope leaves them untouched.
+// A singleton outlives the scope, it is resolved outside of it.
+func (registry *Registry) resolveShared(res *resolution, consumer, dep *holder) (*holder, error) {
+	defer res.lock(registry)()
+	if dep.scope == ScopeSingleton && res.scope != nil {
+		sc := res.scope
+		res.scope = nil
+		defer func() { res.scope = sc }()
+	}
+	inst, err := registry.resolve(res, consumer, dep)
+	if err != nil {
+		return nil, err
+	}
+	if registry.scheduled != nil {
+		res.late = append(res.late, registry.scheduleLate()...)
+	}
+	return inst, nil
+}
+
+// instantiate builds and injects a new instance from the provider of tmpl.
+//
+// Instances built during the injection phase join the application lifecycle,
+// instances built in a scope are initialized at once and closed with the scope.
+// Instances built on demand once the registry is running are initialized at once and closed with the registry,
+// they are not holders nor components, so that repeated lookups don't grow the registry, and receive no event
+func (registry *Registry) instantiate(res *resolution, tmpl *holder) (*holder, error) {
+	if err := res.enter(tmpl); err != nil {
+		return nil, err
+	}
+	defer res.leave()
+
+	o, deps, err := registry.call(res, tmpl)
+	if err != nil {
+		return nil, err
+	}
+	inst := &holder{
+		label:    tmpl.label,
+		key:      tmpl.key,
+		o:        o,
+		typ:      tmpl.typ,
+		vtyp:     tmpl.vtyp,
+		prio:     tmpl.prio,
+		deps:     deps,
+		params:   deps,
+		scope:    tmpl.scope,
+		template: tmpl,
+		module:   tmpl.module,
+		private:  tmpl.private,
+		registry: registry,
+	}
+	err = registry.injectHolder(res, inst)
+	if err != nil {
+		return nil, err
+	}
+	if res.scope != nil {
+		// initialized by finish, once the resolution holds no lock
+		res.inits = append(res.inits, inst)
+		return inst, nil
+	}
+	if registry.scheduled != nil {
+		// initialized by finish, the lazy resolution lock is held
+		if c := newComponent(inst.o, inst.typ); c != nil {
+			c.name = inst.String()
+			res.late = append(res.late, lateInit{registry: registry, c: c, prototype: true})
+		}
+		return inst, nil
+	}
+	registry.appendHolder(inst)
+	return inst, registry.declareInterfaces(inst.o, inst.typ)
+}
@@ -0,0 +1,457 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+const RequestScope = "request"
+
+type BuildCounter struct {
+	built int
+}
+
+type Prototype struct {
+	id     int
+	inited bool
+}
+
+func (p *Prototype) OnInit() error {
+	p.inited = true
+	return nil
+}
+
+type PrototypeUserA struct {
+	P *Prototype `inject:"default:Prototype"`
+}
+
+type PrototypeUserB struct {
+	P *Prototype `inject:""`
+}
+
+func TestScope_prototypeShouldBuildAnInstancePerInjectionPoint(t *testing.T) {
+	g := Default()
+
+	c := &BuildCounter{}
+	ua := &PrototypeUserA{}
+	ub := &PrototypeUserB{}
+	err := g.DeclareDefault(c, ua, ub)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareScoped(ScopePrototype, defaultStr, func(c *BuildCounter) *Prototype {
+		c.built++
+		return &Prototype{id: c.built}
+	})
+	if err != nil {
+		t.Fatalf("Error while declaring prototype: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if ua.P == nil || ub.P == nil || ua.P == ub.P {
+		t.Fatalf("Each injection point should get its own instance: %+v, %+v.", ua.P, ub.P)
+	}
+	if c.built != 2 {
+		t.Fatalf("2 instances expected, got %d.", c.built)
+	}
+	if !ua.P.inited || !ub.P.inited {
+		t.Fatal("Prototype instances should be initialized")
+	}
+}
+
+type RequestContext struct {
+	BuildCounter *BuildCounter `inject:""`
+	id           int
+	closed       bool
+}
+
+func (rc *RequestContext) OnClose() error {
+	rc.closed = true
+	return nil
+}
+
+type RequestHandler struct {
+	Context *RequestContext `inject:""`
+}
+
+func newRequestContext(c *BuildCounter) *RequestContext {
+	c.built++
+	return &RequestContext{id: c.built}
+}
+
+func TestScope_customScopeShouldBuildInstancesOnDemand(t *testing.T) {
+	g := Default()
+
+	c := &BuildCounter{}
+	err := g.DeclareDefault(c)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareScoped(RequestScope, defaultStr, newRequestContext, func(rc *RequestContext) *RequestHandler {
+		return &RequestHandler{}
+	})
+	if err != nil {
+		t.Fatalf("Error while declaring scoped providers: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if c.built != 0 {
+		t.Fatal("Scoped instances should not be built before the scope")
+	}
+
+	sc, err := g.NewScope(RequestScope)
+	if err != nil {
+		t.Fatalf("Error while opening scope: %s.", err)
+	}
+	o, err := sc.Get(defaultStr, "RequestHandler")
+	if err != nil {
+		t.Fatalf("Error while getting handler: %s.", err)
+	}
+	rh := o.(*RequestHandler)
+	o, err = sc.Get(defaultStr, "RequestContext")
+	if err != nil {
+		t.Fatalf("Error while getting context: %s.", err)
+	}
+	rc := o.(*RequestContext)
+	if rh.Context != rc || rc.BuildCounter != c || c.built != 1 {
+		t.Fatal("A scope should share its instances")
+	}
+	err = sc.Close()
+	if err != nil {
+		t.Fatalf("Error while closing scope: %s.", err)
+	}
+	if !rc.closed {
+		t.Fatal("Scoped instances should be closed with their scope")
+	}
+	if _, err = sc.Get(defaultStr, "RequestContext"); err == nil {
+		t.Fatal("A closed scope can't build instances")
+	}
+
+	other, _ := g.NewScope(RequestScope)
+	o, _ = other.Get(defaultStr, "RequestContext")
+	if o == rc || c.built != 2 {
+		t.Fatal("Each scope should build its own instances")
+	}
+}
+
+func TestScope_customScopeCannotBeInjectedInSingletons(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(&BuildCounter{}, &RequestHandler{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareScoped(RequestScope, defaultStr, newRequestContext)
+	if err != nil {
+		t.Fatalf("Error while declaring scoped provider: %s.", err)
+	}
+	err = g.DeclareScoped(RequestScope, defaultStr, &Prototype{})
+	if err == nil {
+		t.Fatal("Scoped declaration requires a provider")
+	}
+	err = g.RunApp()
+	if err == nil {
+		t.Fatal("A request scoped service can't be injected in a singleton")
+	}
+}
+
+type ScopedTracer struct {
+	Counter *BuildCounter `inject:""`
+}
+
+func TestScope_concurrentScopesShouldNotRecordInjections(t *testing.T) {
+	g := Default()
+
+	err := g.DeclareDefault(&BuildCounter{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareScoped(RequestScope, defaultStr, func() *ScopedTracer { return &ScopedTracer{} })
+	if err != nil {
+		t.Fatalf("Error while declaring scoped provider: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	recorded := len(g.Injections())
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sc, err := g.NewScope(RequestScope)
+			if err == nil {
+				_, err = sc.Get(defaultStr, "ScopedTracer")
+			}
+			if err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Error while getting scoped instance: %s.", err)
+	}
+	if len(g.Injections()) != recorded {
+		t.Fatalf("Scoped instances should not be recorded, got %d injections instead of %d.", len(g.Injections()), recorded)
+	}
+}
+
+type SharedInfra struct{}
+
+type SharedInfraLazyUser struct {
+	Infra Lazy[*SharedInfra] `inject:""`
+}
+
+type ScopedInfraUser struct {
+	Infra *SharedInfra
+}
+
+func TestScope_concurrentScopesShouldBuildSingletonsOnce(t *testing.T) {
+	g := Default()
+
+	var mu sync.Mutex
+	built := 0
+	err := g.DeclareDefault(func() *SharedInfra {
+		mu.Lock()
+		defer mu.Unlock()
+		built++
+		return &SharedInfra{}
+	}, &SharedInfraLazyUser{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareScoped(RequestScope, defaultStr, func(i *SharedInfra) *ScopedInfraUser { return &ScopedInfraUser{Infra: i} })
+	if err != nil {
+		t.Fatalf("Error while declaring scoped provider: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+
+	var wg sync.WaitGroup
+	users := make([]*ScopedInfraUser, 8)
+	for i := range users {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sc, err := g.NewScope(RequestScope)
+			if err != nil {
+				return
+			}
+			o, err := sc.Get(defaultStr, "ScopedInfraUser")
+			if err == nil {
+				users[i] = o.(*ScopedInfraUser)
+			}
+		}(i)
+	}
+	wg.Wait()
+	for _, u := range users {
+		if u == nil || u.Infra != users[0].Infra {
+			t.Fatal("Every scope should get the same singleton")
+		}
+	}
+	if built != 1 {
+		t.Fatalf("The singleton should be built once, got %d.", built)
+	}
+}
+
+type ScopedAudit struct {
+	Context *RequestContext `inject:""`
+}
+
+type ScopedSession struct {
+	scope *Scope
+	audit *ScopedAudit
+}
+
+func (s *ScopedSession) OnInit() error {
+	o, err := s.scope.Get(defaultStr, "ScopedAudit")
+	if err != nil {
+		return err
+	}
+	s.audit = o.(*ScopedAudit)
+	return nil
+}
+
+func TestScope_onInitShouldResolveInTheSameScope(t *testing.T) {
+	g := Default()
+
+	var sc *Scope
+	err := g.DeclareDefault(&BuildCounter{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareScoped(RequestScope, defaultStr, newRequestContext, func() *ScopedAudit {
+		return &ScopedAudit{}
+	}, func(rc *RequestContext) *ScopedSession {
+		return &ScopedSession{scope: sc}
+	})
+	if err != nil {
+		t.Fatalf("Error while declaring scoped providers: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	sc, _ = g.NewScope(RequestScope)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := sc.Get(defaultStr, "ScopedSession")
+		done <- err
+	}()
+	select {
+	case err = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("A Get from an OnInit should not block on its scope")
+	}
+	if err != nil {
+		t.Fatalf("Error while getting session: %s.", err)
+	}
+	o, _ := sc.Get(defaultStr, "ScopedSession")
+	rc, _ := sc.Get(defaultStr, "RequestContext")
+	if o.(*ScopedSession).audit == nil || o.(*ScopedSession).audit.Context != rc {
+		t.Fatal("The OnInit lookup should share the instances of the scope")
+	}
+}
+
+type ScopedWorker struct {
+	Context *RequestContext `inject:""`
+	Proto   *Prototype      `inject:""`
+}
+
+func TestScope_concurrentGetShouldBuildOneInstancePerScope(t *testing.T) {
+	g := Default()
+
+	var mu sync.Mutex
+	c := &BuildCounter{}
+	err := g.DeclareDefault(c)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.DeclareScoped(RequestScope, defaultStr, func(c *BuildCounter) *RequestContext {
+		mu.Lock()
+		defer mu.Unlock()
+		return newRequestContext(c)
+	}, func() *ScopedWorker { return &ScopedWorker{} })
+	if err != nil {
+		t.Fatalf("Error while declaring scoped providers: %s.", err)
+	}
+	err = g.DeclareScoped(ScopePrototype, defaultStr, func() *Prototype { return &Prototype{} })
+	if err != nil {
+		t.Fatalf("Error while declaring prototype: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+
+	const scopes, workers = 4, 4
+	var wg sync.WaitGroup
+	errs := make(chan error, scopes*workers*2)
+	contexts := make([][]*RequestContext, scopes)
+	for i := 0; i < scopes; i++ {
+		sc, err := g.NewScope(RequestScope)
+		if err != nil {
+			t.Fatalf("Error while opening scope: %s.", err)
+		}
+		contexts[i] = make([]*RequestContext, workers)
+		for j := 0; j < workers; j++ {
+			wg.Add(2)
+			go func(i, j int) {
+				defer wg.Done()
+				o, err := sc.Get(defaultStr, "ScopedWorker")
+				if err != nil {
+					errs <- err
+					return
+				}
+				contexts[i][j] = o.(*ScopedWorker).Context
+			}(i, j)
+			go func() {
+				defer wg.Done()
+				// lazily built prototypes are appended to the registry while scopes resolve
+				if _, err := Get[*Prototype](g); err != nil {
+					errs <- err
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatalf("Error while resolving concurrently: %s.", err)
+	}
+	for _, cs := range contexts {
+		for _, rc := range cs {
+			if rc == nil || rc != cs[0] {
+				t.Fatal("Every Get of a scope should share its instance")
+			}
+		}
+	}
+	if c.built != scopes {
+		t.Fatalf("One context per scope expected, got %d.", c.built)
+	}
+}
+
+type ClosingPrototype struct {
+	inited bool
+	closed *int
+}
+
+func (p *ClosingPrototype) OnInit() error {
+	p.inited = true
+	return nil
+}
+
+func (p *ClosingPrototype) OnClose() error {
+	*p.closed++
+	return nil
+}
+
+func TestScope_prototypeLookupsShouldNotGrowTheRegistry(t *testing.T) {
+	g := Default()
+	closed := 0
+	err := g.DeclareScoped(ScopePrototype, defaultStr, func() *ClosingPrototype { return &ClosingPrototype{closed: &closed} })
+	if err != nil {
+		t.Fatalf("Error while declaring prototype: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	holders, components := len(g.registry.holderList()), len(g.registry.components)
+	for i := 0; i < 100; i++ {
+		p, err := Get[*ClosingPrototype](g)
+		if err != nil {
+			t.Fatalf("Error while getting prototype: %s.", err)
+		}
+		if !p.inited {
+			t.Fatal("A prototype built on demand should be initialized")
+		}
+	}
+	if len(g.registry.holderList()) != holders || len(g.registry.components) != components {
+		t.Fatal("Prototypes built on demand should not be added to the holders nor the components")
+	}
+	err = g.CloseApp()
+	if err != nil {
+		t.Fatalf("Error while closing app: %s.", err)
+	}
+	if closed != 100 {
+		t.Fatalf("Prototypes built on demand should be closed with the app, got %d.", closed)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// sectionType returns the struct type of a config section, a struct or a pointer to a struct with config tags.
+//
+// Structs parsed from strings, like url.URL or a TextUnmarshaler, are values, not sections
+func sectionType(typ reflect.Type, tag string) (reflect.Type, bool) {
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct || typ == urlType || reflect.PtrTo(typ).Implements(textUnmarshalerType) {
+		return nil, false
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		if typ.Field(i).Tag.Get(tag) != "" {
+			return typ, true
+		}
+	}
+	return nil, false
+}
+
+// checkSection validates the config tags of the section bound to a field of type typ, and of the sections it holds.
+//
+// Struct sections are bound key by key, so they can't be recursive nor have options.
+// The elements of maps and slices are filled from a whole value, their config tags are checked too
+func checkSection(typ reflect.Type, ct configTag, tag string, path []reflect.Type) error {
+	direct := true
+	for typ.Kind() == reflect.Map || typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+		typ, direct = typ.Elem(), false
+	}
+	st, ok := sectionType(typ, tag)
+	if !ok {
+		return nil
+	}
+	for _, p := range path {
+		if p != st {
+			continue
+		}
+		if direct {
+			return newError(fmt.Errorf("config section %s of type %s is recursive", ct.key, st)).SetErrType(ErrTypeRegistry)
+		}
+		return nil
+	}
+	if direct && (ct.required || ct.hasDefault) {
+		return newError(fmt.Errorf("config section %s can't be required or have a default, set them on its fields", ct.key)).SetErrType(ErrTypeRegistry)
+	}
+	path = append(path, st)
+	for i := 0; i < st.NumField(); i++ {
+		field := st.Field(i)
+		ctag := field.Tag.Get(tag)
+		if ctag == "" {
+			continue
+		}
+		fct, err := parseConfigTag(ctag)
+		if err != nil {
+			return err
+		}
+		fct.key = ct.key + "." + fct.key
+		err = checkSection(field.Type, fct, tag, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+// Copyright 2018 ekino.  All rights reserved.
+// Use of this source code is governed by a MIT style
+// license that can be found in the LICENSE file.
+
+package godim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type PoolSection struct {
+	Size    int           `config:"size,default=10"`
+	Timeout time.Duration `config:"timeout,default=1s"`
+}
+
+type DBSection struct {
+	Host     string       `config:"host"`
+	Port     int          `config:"port,default=5432"`
+	Password string       `config:"password,required"`
+	Pool     *PoolSection `config:"pool"`
+}
+
+type Tenant struct {
+	Name  string `config:"name,required"`
+	Quota int    `config:"quota,default=100"`
+	Plan  string `config:"billing.plan"`
+}
+
+type SectionUser struct {
+	DB      DBSection         `config:"db"`
+	Replica *DBSection        `config:"replica"`
+	Tenants map[string]Tenant `config:"tenants"`
+	Admins  []Tenant          `config:"admins"`
+}
+
+func TestGodim_configure_shouldBindSections(t *testing.T) {
+	values := map[string]interface{}{
+		"db.host":           "db.local",
+		"db.password":       "secret",
+		"db.pool.size":      "20",
+		"replica.host":      "replica.local",
+		"replica.port":      5433,
+		"replica.password":  "secret",
+		"replica.pool.size": nil,
+		"tenants": map[string]interface{}{
+			"acme": map[string]interface{}{"name": "Acme", "quota": "5", "billing": map[string]interface{}{"plan": "gold"}},
+			"init": map[string]interface{}{"name": "Initech", "billing.plan": "free"},
+		},
+		"admins": []interface{}{
+			map[string]interface{}{"name": "root"},
+		},
+	}
+	g := NewConfig().WithConfigurationFunction(mapConfig(values)).Build()
+	su := &SectionUser{}
+	err := g.DeclareDefault(su)
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err != nil {
+		t.Fatalf("Error while running app: %s.", err)
+	}
+	if su.DB.Host != "db.local" || su.DB.Port != 5432 || su.DB.Password != "secret" {
+		t.Fatalf("db section should be bound key by key, got %+v", su.DB)
+	}
+	if su.DB.Pool == nil || su.DB.Pool.Size != 20 || su.DB.Pool.Timeout != time.Second {
+		t.Fatalf("nested pointer section should be created and bound, got %+v", su.DB.Pool)
+	}
+	if su.Replica == nil || su.Replica.Host != "replica.local" || su.Replica.Port != 5433 || su.Replica.Pool.Size != 10 {
+		t.Fatalf("replica section should be bound, got %+v", su.Replica)
+	}
+	acme, initech := su.Tenants["acme"], su.Tenants["init"]
+	if len(su.Tenants) != 2 || acme.Name != "Acme" || acme.Quota != 5 || acme.Plan != "gold" {
+		t.Fatalf("map of sections should be bound, got %+v", su.Tenants)
+	}
+	if initech.Quota != 100 || initech.Plan != "free" {
+		t.Fatalf("defaults and dotted keys should apply to map entries, got %+v", initech)
+	}
+	if len(su.Admins) != 1 || su.Admins[0].Name != "root" || su.Admins[0].Quota != 100 {
+		t.Fatalf("slice of sections should be bound, got %+v", su.Admins)
+	}
+}
+
+func TestGodim_configure_shouldReportMissingKeysOfSections(t *testing.T) {
+	values := map[string]interface{}{
+		"db.host":          "db.local",
+		"replica.host":     "replica.local",
+		"replica.port":     5433,
+		"tenants":          map[string]interface{}{},
+		"admins":           nil,
+		"replica.password": nil,
+	}
+	g := NewConfig().WithConfigurationFunction(mapConfig(values)).Build()
+	err := g.DeclareDefault(&SectionUser{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	var me *MultiError
+	if !errors.As(err, &me) || len(me.Errors) != 2 {
+		t.Fatalf("every missing key should be reported, got %v.", err)
+	}
+	if !strings.Contains(err.Error(), "db.password") || !strings.Contains(err.Error(), "replica.password") {
+		t.Fatalf("missing keys should be prefixed by their section, got %s.", err)
+	}
+}
+
+func TestGodim_configure_shouldFailOnMissingKeyOfMapEntry(t *testing.T) {
+	type TenantsUser struct {
+		Tenants map[string]Tenant `config:"tenants"`
+	}
+	values := map[string]interface{}{
+		"tenants": map[string]interface{}{"acme": map[string]interface{}{"quota": 5}},
+	}
+	g := NewConfig().WithConfigurationFunction(mapConfig(values)).Build()
+	err := g.DeclareDefault(&TenantsUser{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+	err = g.RunApp()
+	if err == nil || !strings.Contains(err.Error(), "config key tenants") || !strings.Contains(err.Error(), "name") {
+		t.Fatalf("a missing required key of an entry should fail, got %v.", err)
+	}
+}
+
+type RecursiveSection struct {
+	Name   string            `config:"name"`
+	Parent *RecursiveSection `config:"parent"`
+}
+
+type TreeSection struct {
+	Name     string        `config:"name"`
+	Children []TreeSection `config:"children"`
+}
+
+func TestGodim_Declare_shouldCheckSections(t *testing.T) {
+	type RecursiveUser struct {
+		Node RecursiveSection `config:"node"`
+	}
+	type OptionUser struct {
+		DB DBSection `config:"db,required"`
+	}
+	type BadTagUser struct {
+		Tenants []struct {
+			Name string `config:"name,mandatory"`
+		} `config:"tenants"`
+	}
+	type TreeUser struct {
+		Tree TreeSection `config:"tree"`
+	}
+	g := NewConfig().Build()
+	tests := []struct {
+		o   interface{}
+		err string
+	}{
+		{&RecursiveUser{}, "config section node.parent of type godim.RecursiveSection is recursive"},
+		{&OptionUser{}, "config section db can't be required or have a default"},
+		{&BadTagUser{}, "unknown option mandatory"},
+	}
+	for _, tt := range tests {
+		err := g.DeclareDefault(tt.o)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Fatalf("expected error %q, got %v", tt.err, err)
+		}
+	}
+	err := g.DeclareDefault(&TreeUser{})
+	if err != nil {
+		t.Fatalf("Error while declaring default: %s.", err)
+	}
+}